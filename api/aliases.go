@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultYouModel 是非严格模式下未知模型使用的 you.com 模型
+const defaultYouModel = "deepseek_v3"
+
+// builtinAliases 是常见的带日期版本号的模型名，目标可以是 modelMap 中的名称或 you.com 模型 ID
+var builtinAliases = map[string]string{
+	"gpt-4o-2024-*":        "gpt-4o",
+	"gpt-4o-mini-2024-*":   "gpt-4o-mini",
+	"gpt-4-turbo-2024-*":   "gpt-4-turbo",
+	"gpt-3.5-turbo-*":      "gpt-3.5-turbo",
+	"o1-2024-*":            "o1",
+	"o1-mini-2024-*":       "o1-mini",
+	"o1-preview-2024-*":    "o1-preview",
+	"o3-mini":              "o3-mini-medium",
+	"o3-mini-2025-*":       "o3-mini-medium",
+	"claude-3-opus-*":      "claude-3-opus",
+	"claude-3-sonnet-*":    "claude-3-sonnet",
+	"claude-3-5-sonnet-*":  "claude-3.5-sonnet",
+	"claude-3-5-haiku-*":   "claude-3.5-haiku",
+	"gemini-1.5-pro-*":     "gemini-1.5-pro",
+	"gemini-1.5-flash-*":   "gemini-1.5-flash",
+	"deepseek-r1":          "deepseek-reasoner",
+	"deepseek-v3":          "deepseek-chat",
+	"llama-3.1-405b-*":     "llama-3.1-405b",
+	"qwen-2.5-coder-32b-*": "qwen-2.5-coder-32b",
+}
+
+// modelAlias 是一条别名规则，Pattern 中的 * 匹配任意字符
+type modelAlias struct {
+	Pattern string
+	Target  string
+}
+
+// modelResolver 将请求中的模型名解析为 you.com 模型 ID。
+//
+// 解析顺序：modelMap 精确匹配或已知的 you.com 模型 ID → 别名（精确别名优先，
+// 其次按字面前缀由长到短匹配通配符）→ 透传模式下原样发送 → 严格模式返回 404，否则使用 deepseek_v3。
+type modelResolver struct {
+	aliases     []modelAlias
+	strict      bool
+	passthrough bool
+}
+
+// newModelResolverFromEnv 读取 MODEL_STRICT、MODEL_PASSTHROUGH、MODEL_ALIASES 和 MODEL_ALIASES_FILE
+func newModelResolverFromEnv() *modelResolver {
+	aliases := make(map[string]string, len(builtinAliases))
+	for pattern, target := range builtinAliases {
+		aliases[pattern] = target
+	}
+
+	if path := os.Getenv("MODEL_ALIASES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var fileAliases map[string]string
+			if err = json.Unmarshal(data, &fileAliases); err == nil {
+				for pattern, target := range fileAliases {
+					aliases[pattern] = target
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR [models]: cannot load MODEL_ALIASES_FILE %s: %v", path, err)
+		}
+	}
+
+	// MODEL_ALIASES=gpt-4o-2024-*=gpt_4o,my-model=claude-3.5-sonnet
+	for _, entry := range strings.Split(os.Getenv("MODEL_ALIASES"), ",") {
+		pattern, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || pattern == "" || target == "" {
+			if strings.TrimSpace(entry) != "" {
+				log.Printf("ERROR [models]: ignoring invalid MODEL_ALIASES entry %q", entry)
+			}
+			continue
+		}
+		aliases[strings.TrimSpace(pattern)] = strings.TrimSpace(target)
+	}
+
+	return newModelResolver(aliases, os.Getenv("MODEL_STRICT") == "true", os.Getenv("MODEL_PASSTHROUGH") == "true")
+}
+
+func newModelResolver(aliases map[string]string, strict, passthrough bool) *modelResolver {
+	res := &modelResolver{strict: strict, passthrough: passthrough}
+	for pattern, target := range aliases {
+		res.aliases = append(res.aliases, modelAlias{Pattern: pattern, Target: target})
+	}
+	// 精确别名在前，通配符按字面前缀长度降序，保证更具体的规则优先
+	sort.Slice(res.aliases, func(i, j int) bool {
+		a, b := res.aliases[i], res.aliases[j]
+		aWild, bWild := strings.Contains(a.Pattern, "*"), strings.Contains(b.Pattern, "*")
+		if aWild != bWild {
+			return !aWild
+		}
+		aPrefix, bPrefix := wildcardPrefix(a.Pattern), wildcardPrefix(b.Pattern)
+		if len(aPrefix) != len(bPrefix) {
+			return len(aPrefix) > len(bPrefix)
+		}
+		return a.Pattern < b.Pattern
+	})
+	return res
+}
+
+// Resolve 返回 you.com 模型 ID
+func (res *modelResolver) Resolve(model string) (string, *APIError) {
+	if model == "" {
+		if res.strict {
+			return "", errInvalidRequest("you must provide a model parameter").withParam("model")
+		}
+		return defaultYouModel, nil
+	}
+
+	if youModel, ok := res.lookup(model); ok {
+		return youModel, nil
+	}
+
+	for _, alias := range res.aliases {
+		if matchWildcard(alias.Pattern, model) {
+			if youModel, ok := res.lookup(alias.Target); ok {
+				return youModel, nil
+			}
+			// 别名目标既不在 modelMap 中也不是已知 ID，按 you.com 模型 ID 原样发送
+			return alias.Target, nil
+		}
+	}
+
+	if res.passthrough {
+		return model, nil
+	}
+	if res.strict {
+		return "", errModelNotFound(model)
+	}
+
+	log.Printf("WARNING [models]: unknown model %q, using %s", model, defaultYouModel)
+	return defaultYouModel, nil
+}
+
+// lookup 在 modelMap 和已知的 you.com 模型 ID 中查找
+func (res *modelResolver) lookup(model string) (string, bool) {
+	if youModel, ok := modelMap[model]; ok {
+		return youModel, true
+	}
+	if _, ok := getReverseModelMap()[model]; ok {
+		return model, true
+	}
+	return "", false
+}
+
+// wildcardPrefix 返回第一个 * 之前的字面部分
+func wildcardPrefix(pattern string) string {
+	if i := strings.IndexByte(pattern, '*'); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// matchWildcard 判断 s 是否匹配 pattern，pattern 中只有 * 是通配符
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// defaultModelResolver 是全局模型解析器，测试中可以替换
+var defaultModelResolver = newModelResolverFromEnv()
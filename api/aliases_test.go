@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"gpt-4o", "gpt-4o", true},
+		{"gpt-4o", "gpt-4o-mini", false},
+		{"gpt-4o-2024-*", "gpt-4o-2024-08-06", true},
+		{"gpt-4o-2024-*", "gpt-4o-2024-", true},
+		{"gpt-4o-2024-*", "gpt-4o-mini-2024-07-18", false},
+		{"*-latest", "claude-3-5-sonnet-latest", true},
+		{"*-latest", "claude-3-5-sonnet", false},
+		{"claude-*-sonnet-*", "claude-3-5-sonnet-20241022", true},
+		{"claude-*-sonnet-*", "claude-3-5-haiku-20241022", false},
+		{"a*a", "a", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestModelResolverPrecedence(t *testing.T) {
+	res := newModelResolver(map[string]string{
+		"gpt-4o-*":           "gpt-4o",
+		"gpt-4o-mini-*":      "gpt-4o-mini",
+		"gpt-4o-mini-custom": "deepseek-chat",
+		"*":                  "deepseek-reasoner",
+		"my-model":           "custom_you_model",
+	}, false, false)
+
+	tests := map[string]string{
+		"gpt-4o":                 "gpt_4o",      // modelMap 优先于别名
+		"deepseek_r1":            "deepseek_r1", // 已知的 you.com 模型 ID
+		"gpt-4o-mini-custom":     "deepseek_v3", // 精确别名优先于通配符
+		"gpt-4o-mini-2024-07-18": "gpt_4o_mini", // 更长的字面前缀优先
+		"gpt-4o-2024-08-06":      "gpt_4o",
+		"my-model":               "custom_you_model", // 目标不在 modelMap 中时原样发送
+		"something-else":         "deepseek_r1",      // 最后匹配 *
+	}
+	for model, want := range tests {
+		if got, apiErr := res.Resolve(model); apiErr != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", model, got, apiErr, want)
+		}
+	}
+}
+
+func TestModelResolverModes(t *testing.T) {
+	aliases := map[string]string{"gpt-4o-2024-*": "gpt-4o"}
+
+	lenient := newModelResolver(aliases, false, false)
+	for _, model := range []string{"", "unknown-model"} {
+		if got, apiErr := lenient.Resolve(model); apiErr != nil || got != defaultYouModel {
+			t.Errorf("lenient Resolve(%q) = %q, %v; want %s", model, got, apiErr, defaultYouModel)
+		}
+	}
+
+	strict := newModelResolver(aliases, true, false)
+	if _, apiErr := strict.Resolve("unknown-model"); apiErr == nil || apiErr.Status != http.StatusNotFound || apiErr.Code == nil || *apiErr.Code != "model_not_found" {
+		t.Errorf("strict unknown model: got %+v, want 404 model_not_found", apiErr)
+	}
+	if _, apiErr := strict.Resolve(""); apiErr == nil || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("strict empty model: got %+v, want 400", apiErr)
+	}
+	if got, apiErr := strict.Resolve("gpt-4o-2024-11-20"); apiErr != nil || got != "gpt_4o" {
+		t.Errorf("strict alias: got %q, %v", got, apiErr)
+	}
+
+	// 透传优先于严格模式，但已知模型和别名仍然先解析
+	passthrough := newModelResolver(aliases, true, true)
+	for model, want := range map[string]string{"new_you_model": "new_you_model", "gpt-4o-2024-05-13": "gpt_4o"} {
+		if got, apiErr := passthrough.Resolve(model); apiErr != nil || got != want {
+			t.Errorf("passthrough Resolve(%q) = %q, %v; want %q", model, got, apiErr, want)
+		}
+	}
+}
+
+func TestModelResolverFromEnv(t *testing.T) {
+	t.Setenv("MODEL_ALIASES", "team-model=claude-3.5-sonnet, broken-entry ,gpt-4o-2024-*=gpt_4o_mini")
+	t.Setenv("MODEL_ALIASES_FILE", "")
+	t.Setenv("MODEL_STRICT", "true")
+	t.Setenv("MODEL_PASSTHROUGH", "")
+	res := newModelResolverFromEnv()
+
+	tests := map[string]string{
+		"team-model":        "claude_3_5_sonnet",
+		"gpt-4o-2024-08-06": "gpt_4o_mini", // 环境变量覆盖内置别名
+		"o1-2024-12-17":     "openai_o1",   // 内置别名
+	}
+	for model, want := range tests {
+		if got, apiErr := res.Resolve(model); apiErr != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", model, got, apiErr, want)
+		}
+	}
+	if _, apiErr := res.Resolve("broken-entry"); apiErr == nil {
+		t.Error("invalid MODEL_ALIASES entry was registered")
+	}
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// AnthropicRequest 对应 Anthropic Messages API 的请求体
+type AnthropicRequest struct {
+	Model         string          `json:"model"`
+	Messages      []Message       `json:"messages"`
+	System        json.RawMessage `json:"system,omitempty"` // 字符串或 text 块数组
+	MaxTokens     *int            `json:"max_tokens"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Stream        bool            `json:"stream"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	Metadata      struct {
+		UserID string `json:"user_id,omitempty"`
+	} `json:"metadata"`
+}
+
+// AnthropicContentBlock 是响应中的内容块
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AnthropicUsage 对应 Anthropic 响应中的 usage
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicResponse 对应 Anthropic Messages API 的响应，流式响应的 message_start 事件也使用该结构
+type AnthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicHandler 处理 /v1/messages 请求：将 Anthropic 格式转换为 OpenAIRequest，
+// 复用 Chat Completions 的校验、模型解析和上游处理链，再以 Anthropic 格式返回
+func AnthropicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rc := newRequestContext(w, r, "", false)
+	rc.ResponseID = "msg_" + randomID()
+	rc.Format = &anthropicFormat{}
+
+	if r.Method != "POST" {
+		writeAPIError(w, rc, errMethodNotAllowed(r.Method))
+		return
+	}
+
+	apiKey := authenticate(w, r, rc)
+	if apiKey == nil {
+		return
+	}
+
+	var anthropicReq AnthropicRequest
+	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
+		log.Printf("[%s] Failed to decode request body: %v", rc.RequestID, err)
+		writeAPIError(w, rc, errInvalidRequest("Invalid request body: "+err.Error()))
+		return
+	}
+	openAIReq, apiErr := anthropicReq.toOpenAI()
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	youModel, apiErr := mapModelName(openAIReq.Model)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	chatReq, apiErr := openAIReq.chatRequest(youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	rc.Model = openAIReq.Model
+	rc.Stream = openAIReq.Stream
+	rc.Key = apiKey
+	rc.Tokenizer = tokenizerFor(youModel)
+	rc.PromptTokens = countPromptTokens(rc.Tokenizer, openAIReq.Messages)
+	rc.Limits = newOutputLimiter(openAIReq.Stop, openAIReq.completionLimit(), rc.Tokenizer)
+	log.Printf("[%s] Processing Anthropic request: key=%s, model=%s, messages=%d, stream=%v, user=%q",
+		rc.RequestID, apiKey.Name, openAIReq.Model, len(openAIReq.Messages), openAIReq.Stream, openAIReq.User)
+
+	var extra []string
+	if anthropicReq.TopK != nil {
+		extra = append(extra, "top_k")
+	}
+	warnIgnoredParams(w, rc, &openAIReq, extra...)
+
+	serveChat(w, r, rc, chatReq)
+}
+
+// toOpenAI 将 Anthropic 请求转换为等价的 OpenAIRequest 并校验
+func (req *AnthropicRequest) toOpenAI() (OpenAIRequest, *APIError) {
+	if req.MaxTokens == nil {
+		return OpenAIRequest{}, errInvalidRequest("max_tokens: Field required").withParam("max_tokens")
+	}
+	if len(req.Messages) == 0 {
+		return OpenAIRequest{}, errInvalidRequest("messages: at least one message is required").withParam("messages")
+	}
+	for _, msg := range req.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return OpenAIRequest{}, errInvalidRequest("messages: roles must be user or assistant; use the top-level system parameter for system prompts").withParam("messages")
+		}
+	}
+
+	system, parts, err := decodeContent(req.System)
+	if err != nil {
+		return OpenAIRequest{}, errInvalidRequest("system: " + err.Error()).withParam("system")
+	}
+
+	openAIReq := OpenAIRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.StopSequences,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		User:        req.Metadata.UserID,
+	}
+	if system != "" || len(parts) > 0 {
+		openAIReq.Messages = append(openAIReq.Messages, Message{Role: "system", Content: system, Parts: parts})
+	}
+	openAIReq.Messages = append(openAIReq.Messages, req.Messages...)
+
+	if apiErr := openAIReq.validate(); apiErr != nil {
+		return openAIReq, apiErr
+	}
+	return openAIReq, nil
+}
+
+// anthropicFormat 是 Anthropic Messages API 格式。流式响应依次发送
+// message_start、content_block_start、若干 content_block_delta、content_block_stop、message_delta、message_stop
+type anthropicFormat struct {
+	started bool // 已经发送 message_start 和 content_block_start
+}
+
+// anthropicStopReason 将 outputLimiter 的截断原因转换为 Anthropic 的 stop_reason 和 stop_sequence
+func anthropicStopReason(rc *requestContext) (*string, *string) {
+	reason := "end_turn"
+	var sequence *string
+	switch rc.Limits.FinishReason() {
+	case "length":
+		reason = "max_tokens"
+	case "stop":
+		if seq := rc.Limits.StopSequence(); seq != "" {
+			reason = "stop_sequence"
+			sequence = &seq
+		}
+	}
+	return &reason, sequence
+}
+
+// begin 发送 message_start 和 content_block_start，重复调用无副作用
+func (f *anthropicFormat) begin(w http.ResponseWriter, rc *requestContext) {
+	if f.started {
+		return
+	}
+	f.started = true
+
+	writeSSEEvent(w, "message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": AnthropicResponse{
+			ID:      rc.ResponseID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   rc.Model,
+			Content: []AnthropicContentBlock{},
+			Usage:   AnthropicUsage{InputTokens: rc.PromptTokens},
+		},
+	})
+	writeSSEEvent(w, "content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": AnthropicContentBlock{Type: "text"},
+	})
+}
+
+func (f *anthropicFormat) writeDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	f.begin(w, rc)
+	writeSSEEvent(w, "content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": 0,
+		"delta": map[string]string{"type": "text_delta", "text": text},
+	})
+	flush(w)
+}
+
+func (f *anthropicFormat) finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	f.begin(w, rc)
+	stopReason, stopSequence := anthropicStopReason(rc)
+	writeSSEEvent(w, "content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": 0,
+	})
+	writeSSEEvent(w, "message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]*string{"stop_reason": stopReason, "stop_sequence": stopSequence},
+		"usage": map[string]int{"output_tokens": rc.Tokenizer.Count(content)},
+	})
+	writeSSEEvent(w, "message_stop", map[string]string{"type": "message_stop"})
+	flush(w)
+}
+
+func (f *anthropicFormat) writeResponse(w http.ResponseWriter, rc *requestContext, content string) {
+	stopReason, stopSequence := anthropicStopReason(rc)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnthropicResponse{
+		ID:           rc.ResponseID,
+		Type:         "message",
+		Role:         "assistant",
+		Model:        rc.Model,
+		Content:      []AnthropicContentBlock{{Type: "text", Text: content}},
+		StopReason:   stopReason,
+		StopSequence: stopSequence,
+		Usage: AnthropicUsage{
+			InputTokens:  rc.PromptTokens,
+			OutputTokens: rc.Tokenizer.Count(content),
+		},
+	})
+}
+
+// writeError 写出 Anthropic 格式的错误 {"type":"error","error":{"type","message"}}
+func (f *anthropicFormat) writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	body := map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    anthropicErrorType(apiErr.Status),
+			"message": apiErr.Message,
+		},
+	}
+	if rc != nil && rc.streamStarted {
+		writeSSEEvent(w, "error", body)
+		flush(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// anthropicErrorType 按状态码返回 Anthropic 的错误类型
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusGatewayTimeout:
+		return "timeout_error"
+	}
+	if status >= 500 {
+		return "api_error"
+	}
+	return "invalid_request_error"
+}
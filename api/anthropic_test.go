@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicHandlerStreamEvents(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"Hello", ", world"}})
+
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":64,"stream":true,"system":"be brief","messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", "test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var events []string
+	for _, m := range regexp.MustCompile(`(?m)^event: (\S+)$`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		events = append(events, m[1])
+	}
+	want := "message_start content_block_start content_block_delta content_block_delta content_block_stop message_delta message_stop"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("events = %s\nwant     %s", got, want)
+	}
+	if !strings.Contains(rec.Body.String(), `"stop_reason":"end_turn"`) {
+		t.Errorf("missing end_turn stop_reason in %s", rec.Body)
+	}
+}
+
+func TestAnthropicHandlerErrors(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"Hello"}})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantType   string
+		wantStatus int
+	}{
+		{"missing max_tokens", `{"model":"claude-3.5-sonnet","messages":[{"role":"user","content":"hi"}]}`, "invalid_request_error", 400},
+		{"system role in messages", `{"model":"claude-3.5-sonnet","max_tokens":1,"messages":[{"role":"system","content":"x"},{"role":"user","content":"hi"}]}`, "invalid_request_error", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(tt.body))
+			req.Header.Set("x-api-key", "test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			var resp struct {
+				Type  string `json:"type"`
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if rec.Code != tt.wantStatus || resp.Type != "error" || resp.Error.Type != tt.wantType {
+				t.Errorf("got %d %s, want %d %s error", rec.Code, rec.Body, tt.wantStatus, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey 是密钥库中的一条记录，只保存密钥的 SHA-256 哈希
+type APIKey struct {
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Disabled  bool       `json:"disabled,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// SystemPrompt 替换该密钥请求的部署默认系统提示（DEFAULT_SYSTEM_PROMPT），只能在密钥文件中配置
+	SystemPrompt string `json:"system_prompt,omitempty"`
+}
+
+var (
+	errMissingKey  = errors.New("missing or invalid authorization header")
+	errUnknownKey  = errors.New("incorrect API key provided")
+	errDisabledKey = errors.New("API key has been disabled")
+	errExpiredKey  = errors.New("API key has expired")
+
+	// errKeyStoreUnavailable 表示 API_KEYS 或密钥文件无法加载，是服务端配置错误而不是客户端的密钥错误
+	errKeyStoreUnavailable = errors.New("key store unavailable")
+)
+
+// KeyStore 保存允许访问的 API 密钥。
+//
+// 密钥来源（可同时使用）：
+//
+//	API_KEYS_FILE  JSON 文件，格式为 {"keys":[{"name":"alice","hash":"<sha256>","disabled":false,"expires_at":"2025-12-31T00:00:00Z","system_prompt":"..."}]}，
+//	               文件修改后自动重新加载，便于在不重启的情况下停用某个人的密钥
+//	API_KEYS       逗号分隔的 name:hash[:expires_at]，expires_at 为 RFC3339 时间
+//
+// 没有配置任何密钥时进入开放模式，接受任意 Bearer 令牌（与早期版本行为一致）。
+type KeyStore struct {
+	mu       sync.RWMutex
+	path     string
+	modTime  time.Time
+	fileKeys map[string]*APIKey
+	envKeys  map[string]*APIKey
+	envErr   error // API_KEYS 解析错误，启动后不再变化
+	fileErr  error // 最近一次加载密钥文件的错误，文件修复后清除
+}
+
+// HashKey 返回密钥的 SHA-256 十六进制哈希，用于生成密钥库条目
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKeyStoreFromEnv 根据 API_KEYS_FILE 和 API_KEYS 创建密钥库
+func NewKeyStoreFromEnv() *KeyStore {
+	ks := &KeyStore{path: os.Getenv("API_KEYS_FILE")}
+
+	envKeys, err := parseEnvKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		log.Printf("ERROR [auth]: invalid API_KEYS: %v", err)
+		ks.envErr = err
+	}
+	ks.envKeys = envKeys
+	ks.reloadIfChanged()
+
+	if ks.open() {
+		log.Printf("WARNING [auth]: no API keys configured, accepting any Bearer token")
+	}
+	return ks
+}
+
+// parseEnvKeys 解析 API_KEYS 环境变量
+func parseEnvKeys(value string) (map[string]*APIKey, error) {
+	keys := make(map[string]*APIKey)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("entry %q is not name:hash[:expires_at]", entry)
+		}
+		key := &APIKey{Name: parts[0], Hash: strings.ToLower(parts[1])}
+		if len(parts) == 3 {
+			expiresAt, err := time.Parse(time.RFC3339, parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %v", parts[0], err)
+			}
+			key.ExpiresAt = &expiresAt
+		}
+		keys[key.Hash] = key
+	}
+	return keys, nil
+}
+
+// reloadIfChanged 在密钥文件修改时间变化后重新加载
+func (ks *KeyStore) reloadIfChanged() {
+	if ks.path == "" {
+		return
+	}
+
+	info, err := os.Stat(ks.path)
+	ks.mu.RLock()
+	unchanged := err == nil && info.ModTime().Equal(ks.modTime) && ks.fileKeys != nil
+	ks.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err != nil {
+		// 文件不可读时拒绝所有请求，而不是退回开放模式
+		log.Printf("ERROR [auth]: cannot read key file %s: %v", ks.path, err)
+		ks.fileErr = err
+		return
+	}
+
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		log.Printf("ERROR [auth]: cannot read key file %s: %v", ks.path, err)
+		ks.fileErr = err
+		return
+	}
+
+	var file struct {
+		Keys []*APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		log.Printf("ERROR [auth]: invalid key file %s: %v", ks.path, err)
+		ks.fileErr = err
+		return
+	}
+
+	keys := make(map[string]*APIKey, len(file.Keys))
+	for _, key := range file.Keys {
+		if key.Hash == "" {
+			continue
+		}
+		key.Hash = strings.ToLower(key.Hash)
+		keys[key.Hash] = key
+	}
+	ks.fileKeys = keys
+	ks.modTime = info.ModTime()
+	ks.fileErr = nil
+	log.Printf("INFO [auth]: loaded %d API keys from %s", len(keys), ks.path)
+}
+
+// open 表示没有配置任何密钥
+func (ks *KeyStore) open() bool {
+	return ks.path == "" && len(ks.envKeys) == 0 && ks.envErr == nil
+}
+
+// Lookup 校验明文密钥，返回对应的记录
+func (ks *KeyStore) Lookup(token string) (*APIKey, error) {
+	ks.reloadIfChanged()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if err := errors.Join(ks.envErr, ks.fileErr); err != nil {
+		return nil, fmt.Errorf("%w: %w", errKeyStoreUnavailable, err)
+	}
+	if ks.open() {
+		return &APIKey{Name: "anonymous"}, nil
+	}
+
+	hash := HashKey(token)
+	key, ok := ks.fileKeys[hash]
+	if !ok {
+		key, ok = ks.envKeys[hash]
+	}
+	switch {
+	case !ok:
+		return nil, errUnknownKey
+	case key.Disabled:
+		return nil, errDisabledKey
+	case key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt):
+		return nil, errExpiredKey
+	}
+	return key, nil
+}
+
+// Authenticate 从 Authorization 请求头中取出 Bearer 令牌并校验；
+// 没有 Authorization 时使用 x-api-key 请求头（Anthropic SDK 的认证方式）
+func (ks *KeyStore) Authenticate(r *http.Request) (*APIKey, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if r.Header.Get("Authorization") == "" {
+		token, ok = r.Header.Get("x-api-key"), true
+	}
+	if !ok || strings.TrimSpace(token) == "" {
+		return nil, errMissingKey
+	}
+	return ks.Lookup(strings.TrimSpace(token))
+}
+
+// keyStore 是全局密钥库，测试中可以替换
+var keyStore = NewKeyStoreFromEnv()
+
+// authenticate 校验请求，失败时按 rc 的响应格式写入 401 invalid_api_key 错误并返回 nil；rc 可以为空。
+// 密钥库无法加载时写入 503
+func authenticate(w http.ResponseWriter, r *http.Request, rc *requestContext) *APIKey {
+	key, err := keyStore.Authenticate(r)
+	if err == nil {
+		return key
+	}
+
+	if errors.Is(err, errKeyStoreUnavailable) {
+		// 详细原因（含文件路径）只写入日志
+		log.Printf("ERROR [auth]: %v", err)
+		writeAPIError(w, rc, errServiceUnavailable("API key configuration could not be loaded. Contact the server administrator."))
+		return nil
+	}
+
+	log.Printf("Authentication failed from IP %s: %v", r.RemoteAddr, err)
+	message := err.Error()
+	if errors.Is(err, errUnknownKey) || errors.Is(err, errMissingKey) {
+		message = "Incorrect API key provided. Check the Authorization: Bearer or x-api-key header."
+	}
+	writeAPIError(w, rc, errInvalidAPIKey(message))
+	return nil
+}
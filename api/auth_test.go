@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestKeyStore 按给定的 API_KEYS 和 API_KEYS_FILE 创建密钥库
+func newTestKeyStore(t *testing.T, envKeys, path string) *KeyStore {
+	t.Helper()
+	t.Setenv("API_KEYS", envKeys)
+	t.Setenv("API_KEYS_FILE", path)
+	return NewKeyStoreFromEnv()
+}
+
+// writeKeyFile 写入密钥文件，并把修改时间设为 modTime，避免同一秒内的两次写入被视为未修改
+func writeKeyFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	if got := HashKey("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("HashKey(abc) = %s", got)
+	}
+}
+
+func TestKeyStoreEnvKeys(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	ks := newTestKeyStore(t, "alice:"+strings.ToUpper(HashKey("alice-key"))+", bob:"+HashKey("bob-key")+":"+expired+",carol:"+HashKey("carol-key")+":"+future, "")
+
+	tests := []struct {
+		token    string
+		wantName string
+		wantErr  error
+	}{
+		{"alice-key", "alice", nil},
+		{"carol-key", "carol", nil},
+		{"bob-key", "", errExpiredKey},
+		{"nobody", "", errUnknownKey},
+		{HashKey("alice-key"), "", errUnknownKey},
+	}
+	for _, tt := range tests {
+		key, err := ks.Lookup(tt.token)
+		if !errors.Is(err, tt.wantErr) || (err == nil && key.Name != tt.wantName) {
+			t.Errorf("Lookup(%q) = %+v, %v; want %s, %v", tt.token, key, err, tt.wantName, tt.wantErr)
+		}
+	}
+}
+
+func TestKeyStoreOpenMode(t *testing.T) {
+	ks := newTestKeyStore(t, "", "")
+	if key, err := ks.Lookup("anything"); err != nil || key.Name != "anonymous" {
+		t.Errorf("open mode Lookup = %+v, %v", key, err)
+	}
+
+	// 配置错误时拒绝所有请求，而不是退回开放模式
+	ks = newTestKeyStore(t, "not-a-valid-entry", "")
+	if _, err := ks.Lookup("anything"); !errors.Is(err, errKeyStoreUnavailable) {
+		t.Errorf("invalid API_KEYS: err = %v, want %v", err, errKeyStoreUnavailable)
+	}
+	ks = newTestKeyStore(t, "", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := ks.Lookup("anything"); !errors.Is(err, errKeyStoreUnavailable) {
+		t.Errorf("missing key file: err = %v, want %v", err, errKeyStoreUnavailable)
+	}
+}
+
+func TestKeyStoreFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	start := time.Now().Add(-time.Hour)
+	writeKeyFile(t, path, `{"keys":[{"name":"alice","hash":"`+HashKey("alice-key")+`"}]}`, start)
+	ks := newTestKeyStore(t, "", path)
+
+	if key, err := ks.Lookup("alice-key"); err != nil || key.Name != "alice" {
+		t.Fatalf("Lookup = %+v, %v", key, err)
+	}
+
+	writeKeyFile(t, path, `{"keys":[{"name":"alice","hash":"`+HashKey("alice-key")+`","disabled":true}]}`, start.Add(time.Minute))
+	if _, err := ks.Lookup("alice-key"); !errors.Is(err, errDisabledKey) {
+		t.Errorf("after disabling: err = %v, want %v", err, errDisabledKey)
+	}
+
+	writeKeyFile(t, path, `{"keys":`, start.Add(2*time.Minute))
+	if _, err := ks.Lookup("alice-key"); err == nil || errors.Is(err, errDisabledKey) {
+		t.Errorf("invalid key file: err = %v, want key store error", err)
+	}
+
+	writeKeyFile(t, path, `{"keys":[{"name":"alice","hash":"`+HashKey("alice-key")+`"}]}`, start.Add(3*time.Minute))
+	if _, err := ks.Lookup("alice-key"); err != nil {
+		t.Errorf("after fixing the file: err = %v", err)
+	}
+}
+
+func TestKeyStoreFileReloadKeepsEnvError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, path, `{"keys":[{"name":"alice","hash":"`+HashKey("alice-key")+`"}]}`, time.Now().Add(-time.Hour))
+	ks := newTestKeyStore(t, "broken", path)
+
+	writeKeyFile(t, path, `{"keys":[{"name":"alice","hash":"`+HashKey("alice-key")+`"}]}`, time.Now())
+	if _, err := ks.Lookup("alice-key"); err == nil {
+		t.Error("successful key file reload hid the API_KEYS error")
+	}
+}
+
+func TestKeyStoreAuthenticate(t *testing.T) {
+	ks := newTestKeyStore(t, "alice:"+HashKey("alice-key"), "")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr error
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer alice-key"}, nil},
+		{"x-api-key", map[string]string{"x-api-key": "alice-key"}, nil},
+		{"authorization wins", map[string]string{"Authorization": "Bearer wrong", "x-api-key": "alice-key"}, errUnknownKey},
+		{"not bearer", map[string]string{"Authorization": "Basic alice-key"}, errMissingKey},
+		{"missing", nil, errMissingKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/models", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if _, err := ks.Authenticate(req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateKeyStoreUnavailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+	old := keyStore
+	keyStore = newTestKeyStore(t, "", path)
+	t.Cleanup(func() { keyStore = old })
+
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer alice-key")
+	rec := httptest.NewRecorder()
+	if key := authenticate(rec, req, nil); key != nil {
+		t.Fatalf("authenticated %+v with an unavailable key store", key)
+	}
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), path) || strings.Contains(rec.Body.String(), "invalid_api_key") {
+		t.Errorf("got %d %s, want 503 without the file path", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultMaxChoices        = 4
+	defaultChoiceConcurrency = 8
+)
+
+// maxChoices 是 n 的上限，由 MAX_CHOICES 配置
+var maxChoices = positiveIntFromEnv("MAX_CHOICES", defaultMaxChoices)
+
+// choiceSlots 是限制 n>1 请求同时进行的上游调用数的信号量，由 CHOICE_CONCURRENCY 配置，所有请求共用。
+// 超出的 choice 排队等待，避免一个大 n 请求瞬间向 you.com 发出大量请求
+var choiceSlots = make(chan struct{}, positiveIntFromEnv("CHOICE_CONCURRENCY", defaultChoiceConcurrency))
+
+// positiveIntFromEnv 读取正整数环境变量，未设置或不合法时返回 def
+func positiveIntFromEnv(env string, def int) int {
+	v := os.Getenv(env)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Printf("ERROR [config]: invalid %s %q, using %d", env, v, def)
+		return def
+	}
+	return n
+}
+
+// choicesWriter 由支持 n>1 的响应格式实现（目前只有 OpenAI Chat Completions）
+type choicesWriter interface {
+	// finishChoice 结束流式响应中的一个 choice
+	finishChoice(w http.ResponseWriter, rc *requestContext, content string)
+	// finishResponse 在所有 choice 结束后结束流式响应
+	finishResponse(w http.ResponseWriter, rc *requestContext, usage *Usage)
+	// writeChoices 写出包含多个 choice 的非流式响应
+	writeChoices(w http.ResponseWriter, rc *requestContext, choices []*requestContext, contents []string)
+}
+
+// choiceEvent 是某个 choice 的上游事件，Done 表示该 choice 已经结束
+type choiceEvent struct {
+	Index int
+	Event
+	Done bool
+}
+
+// serveChoices 为 n>1 的请求并发生成 rc.N 个 choice。每个 choice 独立调用上游（主上游失败或没有回答时改用备用上游），
+// 流式响应按到达顺序交错发送各 choice 的增量，非流式响应等全部完成后一起返回。任何一个 choice 失败时整个请求失败
+func serveChoices(w http.ResponseWriter, r *http.Request, rc *requestContext, chatReq ChatRequest) {
+	format, ok := rc.Format.(choicesWriter)
+	if !ok {
+		writeAPIError(w, rc, errInvalidRequest("n > 1 is not supported on this endpoint").withParam("n"))
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	choices := make([]*requestContext, rc.N)
+	events := make(chan choiceEvent)
+	var wg sync.WaitGroup
+	for i := range choices {
+		choices[i] = rc.forChoice(i)
+		wg.Add(1)
+		go func(choice *requestContext) {
+			defer wg.Done()
+			runChoice(ctx, choice, chatReq, events)
+		}(choices[i])
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	contents := make([]strings.Builder, len(choices))
+	var failure error
+	for ev := range events {
+		choice := choices[ev.Index]
+		switch {
+		case failure != nil:
+			// 已经失败，丢弃其余 choice 的事件直到全部退出
+		case ev.Err != nil:
+			log.Printf("[%s] Choice %d failed: %v", rc.RequestID, ev.Index, ev.Err)
+			failure = ev.Err
+			cancel()
+		case ev.Done && rc.Stream:
+			content := writeFootnotes(w, choice, contents[ev.Index].String())
+			format.finishChoice(w, choice, content)
+		case ev.Done:
+			contents[ev.Index].WriteString(choice.citationFootnotes())
+		case rc.Stream:
+			contents[ev.Index].WriteString(writeStreamEvent(w, choice, ev.Event))
+			rc.streamStarted = rc.streamStarted || choice.streamStarted
+		default:
+			contents[ev.Index].WriteString(collectEvent(choice, ev.Event))
+		}
+	}
+
+	if failure != nil {
+		writeAPIError(w, rc, upstreamFailure(failure))
+		return
+	}
+	results := make([]string, len(choices))
+	for i := range contents {
+		results[i] = contents[i].String()
+	}
+	log.Printf("[%s] Generated %d choices", rc.RequestID, len(choices))
+	if rc.Stream {
+		rc.startStream(w)
+		format.finishResponse(w, rc, totalUsage(choices, results))
+		return
+	}
+	format.writeChoices(w, rc, choices, results)
+}
+
+// runChoice 在信号量限制下为一个 choice 调用上游，将处理后的事件发送到 out，最后发送 Done。
+// 出错或没有任何输出时只发送一个带 Err 的事件
+func runChoice(ctx context.Context, rc *requestContext, chatReq ChatRequest, out chan<- choiceEvent) {
+	send := func(ev choiceEvent) bool {
+		ev.Index = rc.Index
+		select {
+		case out <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case choiceSlots <- struct{}{}:
+		defer func() { <-choiceSlots }()
+	case <-ctx.Done():
+		send(choiceEvent{Event: Event{Err: ctx.Err()}})
+		return
+	}
+
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	produced := false
+	events, err := primaryUpstream.Stream(upstreamCtx, chatReq)
+	if err == nil {
+		var apiErr *APIError
+		if events, apiErr = processEvents(upstreamCtx, cancel, rc, chatReq, events); apiErr != nil {
+			send(choiceEvent{Event: Event{Err: apiErr}})
+			return
+		}
+		for ev := range events {
+			if ev.Err != nil {
+				err = ev.Err
+				continue
+			}
+			produced = produced || ev.Text != "" || ev.Reasoning != "" || len(ev.ToolCalls) > 0
+			if !send(choiceEvent{Event: ev}) {
+				drainEvents(events)
+				return
+			}
+		}
+		if produced && err != nil {
+			// 已经输出部分内容，无法再改用备用上游
+			send(choiceEvent{Event: Event{Err: err}})
+			return
+		}
+	}
+
+	if !produced && !rc.Limits.Truncated() {
+		log.Printf("[%s] Choice %d got no content from %s (%v), trying fallback...", rc.RequestID, rc.Index, primaryUpstream.Name(), err)
+		content, fallbackErr := tryMultipleMethods(ctx, chatReq)
+		if content == "" {
+			if fallbackErr == nil {
+				fallbackErr = err
+			}
+			send(choiceEvent{Event: Event{Err: upstreamFailure(fallbackErr)}})
+			return
+		}
+		fallbackCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, apiErr := processEvents(fallbackCtx, cancel, rc, chatReq, textEvents(content))
+		if apiErr != nil {
+			send(choiceEvent{Event: Event{Err: apiErr}})
+			return
+		}
+		for ev := range events {
+			if !send(choiceEvent{Event: ev}) {
+				drainEvents(events)
+				return
+			}
+		}
+	}
+	send(choiceEvent{Done: true})
+}
+
+// drainEvents 在请求取消后读完剩余事件。处理管道的各阶段不检查 ctx，不读完会一直阻塞在发送上；
+// 上游随 ctx 一起取消，很快就会关闭
+func drainEvents(events <-chan Event) {
+	for range events {
+	}
+}
+
+// totalUsage 汇总各 choice 的用量，prompt 只计一次
+func totalUsage(choices []*requestContext, contents []string) *Usage {
+	total := &Usage{PromptTokens: choices[0].PromptTokens}
+	for i, choice := range choices {
+		usage := choice.usage(contents[i])
+		total.CompletionTokens += usage.CompletionTokens
+		if usage.CompletionTokensDetails != nil {
+			if total.CompletionTokensDetails == nil {
+				total.CompletionTokensDetails = &CompletionTokensDetails{}
+			}
+			total.CompletionTokensDetails.ReasoningTokens += usage.CompletionTokensDetails.ReasoningTokens
+		}
+	}
+	total.TotalTokens = total.PromptTokens + total.CompletionTokens
+	return total
+}
+
+// choiceCitationURLs 合并各 choice 的来源 URL 并去重
+func choiceCitationURLs(choices []*requestContext) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, choice := range choices {
+		for _, url := range choice.citationURLs() {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyUpstream 为每次调用返回不同的回答，并记录同时进行的最大调用数
+type concurrencyUpstream struct {
+	calls, active, peak atomic.Int32
+}
+
+func (u *concurrencyUpstream) Name() string { return "concurrency" }
+
+func (u *concurrencyUpstream) Stream(ctx context.Context, req ChatRequest) (<-chan Event, error) {
+	n := u.calls.Add(1)
+	if active := u.active.Add(1); active > u.peak.Load() {
+		u.peak.Store(active)
+	}
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer u.active.Add(-1)
+		for _, token := range []string{"answer ", fmt.Sprint(n)} {
+			time.Sleep(5 * time.Millisecond)
+			select {
+			case events <- Event{Text: token}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func withChoiceSlots(t *testing.T, n int) {
+	t.Helper()
+	old := choiceSlots
+	choiceSlots = make(chan struct{}, n)
+	t.Cleanup(func() { choiceSlots = old })
+}
+
+func TestChatMultipleChoices(t *testing.T) {
+	upstream := &concurrencyUpstream{}
+	withUpstreams(t, upstream)
+	withChoiceSlots(t, 2)
+
+	body := `{"model":"gpt-4o","n":3,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var resp OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 3 {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	seen := make(map[string]bool)
+	for i, choice := range resp.Choices {
+		if choice.Index != i || choice.FinishReason != "stop" || !strings.HasPrefix(choice.Message.Content, "answer ") {
+			t.Errorf("choice %d = %+v", i, choice)
+		}
+		seen[choice.Message.Content] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("choices are not independent samples: %+v", resp.Choices)
+	}
+	youModel, _ := mapModelName("gpt-4o")
+	if want := 3 * tokenizerFor(youModel).Count("answer 1"); resp.Usage.CompletionTokens != want {
+		t.Errorf("completion_tokens = %d, want %d summed over choices", resp.Usage.CompletionTokens, want)
+	}
+	if peak := upstream.peak.Load(); peak > 2 {
+		t.Errorf("peak concurrency = %d, want at most 2", peak)
+	}
+}
+
+func TestChatMultipleChoicesStream(t *testing.T) {
+	withUpstreams(t, &concurrencyUpstream{})
+	withChoiceSlots(t, 4)
+
+	body := `{"model":"gpt-4o","n":2,"stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var contents [2]strings.Builder
+	finished := map[int]string{}
+	done, usageChunks := 0, 0
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done++
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		if chunk.Usage != nil {
+			usageChunks++
+		}
+		for _, c := range chunk.Choices {
+			if c.Index < 0 || c.Index > 1 {
+				t.Fatalf("unexpected index in %s", data)
+			}
+			if _, ok := finished[c.Index]; ok {
+				t.Errorf("chunk after finish for choice %d: %s", c.Index, data)
+			}
+			contents[c.Index].WriteString(c.Delta.Content)
+			if c.FinishReason != "" {
+				finished[c.Index] = c.FinishReason
+			}
+		}
+	}
+	for i := range contents {
+		if !strings.HasPrefix(contents[i].String(), "answer ") || finished[i] != "stop" {
+			t.Errorf("choice %d: content = %q, finish = %q", i, contents[i].String(), finished[i])
+		}
+	}
+	if done != 1 || usageChunks != 1 {
+		t.Errorf("got %d [DONE] and %d usage chunks, want 1 each", done, usageChunks)
+	}
+}
+
+func TestChatMultipleChoicesFailure(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	withUpstreams(t, &MockUpstream{Reply: func(req ChatRequest) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 2 {
+			return nil
+		}
+		return []string{"ok"}
+	}})
+
+	body := `{"model":"gpt-4o","n":2,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChoicesLimit(t *testing.T) {
+	n := maxChoices + 1
+	req := OpenAIRequest{N: &n}
+	if apiErr := req.validate(); apiErr == nil || apiErr.Param == nil || *apiErr.Param != "n" {
+		t.Errorf("got %v, want error on n", apiErr)
+	}
+}
+
+func TestRunChoiceCancelledReleasesPipeline(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"a", "b", "c", "d"}})
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := newRequestContext(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/chat/completions", nil), "gpt-4o", false)
+	rc.Tokenizer = cjkTokenizer
+	rc.Limits = newOutputLimiter(nil, 0, rc.Tokenizer)
+	out := make(chan choiceEvent)
+	done := make(chan struct{})
+	go func() {
+		runChoice(ctx, rc, ChatRequest{}, out)
+		close(done)
+	}()
+
+	// 收到第一个事件后等后续事件填满处理管道，再模拟另一个 choice 失败，之后不再读取 out
+	<-out
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runChoice did not return after cancel")
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, want %d", runtime.NumGoroutine(), before)
+		}
+	}
+}
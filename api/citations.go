@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// 搜索来源的输出方式
+const (
+	citationsOff         = "off"         // 不返回来源
+	citationsAnnotations = "annotations" // OpenAI 风格的 url_citation 注解，并附加扩展字段 citations
+	citationsMarkdown    = "markdown"    // 在回答末尾以 Markdown 列表列出来源，适合只显示文本的聊天界面
+)
+
+// Citation 是 you.com 搜索结果中的一个来源
+type Citation struct {
+	URL     string `json:"url"`
+	Title   string `json:"title,omitempty"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Annotation 对应 OpenAI Chat Completions 中 message.annotations 的一项
+type Annotation struct {
+	Type        string       `json:"type"`
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
+}
+
+// URLCitation 是 url_citation 注解的内容，索引按字符计算
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+}
+
+// ResponseURLCitation 是 Responses API 中 output_text 的 url_citation 注解
+type ResponseURLCitation struct {
+	Type       string `json:"type"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+}
+
+// defaultCitationMode 是部署默认的来源输出方式，由 CITATION_MODE 配置，默认 annotations
+var defaultCitationMode = citationModeFromEnv()
+
+func citationModeFromEnv() string {
+	mode := os.Getenv("CITATION_MODE")
+	if mode == "" {
+		return citationsAnnotations
+	}
+	if !validCitationMode(mode) {
+		log.Printf("ERROR [citations]: invalid CITATION_MODE %q, using %s", mode, citationsAnnotations)
+		return citationsAnnotations
+	}
+	return mode
+}
+
+func validCitationMode(mode string) bool {
+	return mode == citationsOff || mode == citationsAnnotations || mode == citationsMarkdown
+}
+
+// citationMode 返回请求的来源输出方式，未设置时使用部署默认值
+func (req *OpenAIRequest) citationMode() (string, *APIError) {
+	if req.CitationMode == "" {
+		return defaultCitationMode, nil
+	}
+	if !validCitationMode(req.CitationMode) {
+		return "", errInvalidRequest(fmt.Sprintf("citation_mode must be one of %s, %s or %s", citationsOff, citationsAnnotations, citationsMarkdown)).withParam("citation_mode")
+	}
+	return req.CitationMode, nil
+}
+
+// addCitations 记录本次请求的来源，按 URL 去重并保持首次出现的顺序
+func (rc *requestContext) addCitations(citations []Citation) {
+	for _, c := range citations {
+		duplicate := false
+		for _, existing := range rc.Citations {
+			if existing.URL == c.URL {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			rc.Citations = append(rc.Citations, c)
+		}
+	}
+}
+
+// citationSpan 返回来源在回答中的字符区间：回答中出现了该 URL 时指向第一次出现的位置，
+// 否则为回答末尾的空区间
+func citationSpan(content, url string) (int, int) {
+	if idx := strings.Index(content, url); idx >= 0 {
+		start := utf8.RuneCountInString(content[:idx])
+		return start, start + utf8.RuneCountInString(url)
+	}
+	end := utf8.RuneCountInString(content)
+	return end, end
+}
+
+// annotations 返回 annotations 模式下的 url_citation 注解，其他模式或没有来源时为空
+func (rc *requestContext) annotations(content string) []Annotation {
+	if rc.CitationMode != citationsAnnotations {
+		return nil
+	}
+	var annotations []Annotation
+	for _, c := range rc.Citations {
+		start, end := citationSpan(content, c.URL)
+		annotations = append(annotations, Annotation{
+			Type:        "url_citation",
+			URLCitation: &URLCitation{URL: c.URL, Title: c.Title, StartIndex: start, EndIndex: end},
+		})
+	}
+	return annotations
+}
+
+// citationURLs 返回扩展字段 citations 的 URL 列表，只在 annotations 模式下返回
+func (rc *requestContext) citationURLs() []string {
+	if rc.CitationMode != citationsAnnotations {
+		return nil
+	}
+	var urls []string
+	for _, c := range rc.Citations {
+		urls = append(urls, c.URL)
+	}
+	return urls
+}
+
+// citationFootnotes 返回 markdown 模式下追加在回答末尾的来源列表，其他模式或没有来源时为空
+func (rc *requestContext) citationFootnotes() string {
+	if rc.CitationMode != citationsMarkdown || len(rc.Citations) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n**Sources:**\n")
+	for i, c := range rc.Citations {
+		title := c.Title
+		if title == "" {
+			title = c.URL
+		}
+		title = strings.NewReplacer("[", "\\[", "]", "\\]").Replace(title)
+		fmt.Fprintf(&b, "%d. [%s](%s)\n", i+1, title, c.URL)
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newSearchServer 返回一个先发送搜索结果、再发送回答的模拟 you.com 服务
+func newSearchServer(t *testing.T, tokens ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: thirdPartySearchResults\n")
+		fmt.Fprint(w, `data: {"search":{"third_party_search_results":[{"url":"https://go.dev/doc","name":"Go docs","snippet":"..."},{"url":"https://example.com","name":"Example"},{"url":"https://go.dev/doc","name":"dup"}]}}`+"\n\n")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "event: youChatToken\ndata: {\"youChatToken\":%q}\n\n", token)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestChatCitationAnnotations(t *testing.T) {
+	srv := newSearchServer(t, "See https://go.dev/doc", " for details.")
+	withUpstreams(t, &YouUpstream{Endpoint: srv.URL, Client: srv.Client()})
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"go docs?"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var resp OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	annotations := resp.Choices[0].Message.Annotations
+	if len(annotations) != 2 {
+		t.Fatalf("got %d annotations, want 2 (deduplicated): %s", len(annotations), rec.Body)
+	}
+	first := annotations[0].URLCitation
+	if annotations[0].Type != "url_citation" || first.URL != "https://go.dev/doc" || first.Title != "Go docs" || first.StartIndex != 4 || first.EndIndex != 22 {
+		t.Errorf("first annotation = %+v", first)
+	}
+	if len(resp.Citations) != 2 || resp.Citations[1] != "https://example.com" {
+		t.Errorf("citations = %v", resp.Citations)
+	}
+	if resp.Choices[0].Message.Content != "See https://go.dev/doc for details." {
+		t.Errorf("content = %q", resp.Choices[0].Message.Content)
+	}
+}
+
+func TestChatCitationMarkdown(t *testing.T) {
+	srv := newSearchServer(t, "Answer.")
+	withUpstreams(t, &YouUpstream{Endpoint: srv.URL, Client: srv.Client()})
+
+	body := `{"model":"gpt-4o","stream":true,"citation_mode":"markdown","messages":[{"role":"user","content":"go docs?"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var content strings.Builder
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		if len(chunk.Choices) > 0 && len(chunk.Choices[0].Delta.Annotations) > 0 {
+			t.Errorf("unexpected annotations in markdown mode: %s", data)
+		}
+		for _, c := range chunk.Choices {
+			content.WriteString(c.Delta.Content)
+		}
+	}
+	want := "Answer.\n\n**Sources:**\n1. [Go docs](https://go.dev/doc)\n2. [Example](https://example.com)\n"
+	if content.String() != want {
+		t.Errorf("content = %q, want %q", content.String(), want)
+	}
+}
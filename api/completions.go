@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// CompletionRequest 对应旧版 Completions API（POST /v1/completions）的请求体
+type CompletionRequest struct {
+	Model            string         `json:"model"`
+	Prompt           PromptInput    `json:"prompt"`
+	Suffix           string         `json:"suffix,omitempty"`
+	Echo             bool           `json:"echo,omitempty"`
+	Stream           bool           `json:"stream"`
+	MaxTokens        *int           `json:"max_tokens,omitempty"` // 旧版接口默认 16，这里不设置时不限制
+	Stop             StopSequences  `json:"stop,omitempty"`
+	Temperature      *float64       `json:"temperature,omitempty"`
+	TopP             *float64       `json:"top_p,omitempty"`
+	N                *int           `json:"n,omitempty"`
+	Logprobs         *int           `json:"logprobs,omitempty"`
+	BestOf           *int           `json:"best_of,omitempty"`
+	PresencePenalty  *float64       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64       `json:"frequency_penalty,omitempty"`
+	User             string         `json:"user,omitempty"`
+	Seed             *int64         `json:"seed,omitempty"`
+	StreamOptions    *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// PromptInput 对应 prompt 参数，可以是字符串或字符串数组；token 数组无法还原为文本，不支持
+type PromptInput []string
+
+func (p *PromptInput) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*p = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		*p = PromptInput{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("prompt must be a string or an array of strings; token arrays are not supported")
+	}
+	*p = list
+	return nil
+}
+
+// CompletionChoice 是 text_completion 中的一个结果
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// CompletionResponse 是 text_completion 对象，流式响应的每个块也使用该结构
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+// CompletionsHandler 处理 /v1/completions 请求：prompt 转换为一条 user 消息，
+// 复用 Chat Completions 的校验、模型解析和上游处理链，再以 text_completion 格式返回
+func CompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rc := newRequestContext(w, r, "", false)
+	rc.ResponseID = "cmpl-" + randomID()
+
+	if r.Method != "POST" {
+		writeAPIError(w, rc, errMethodNotAllowed(r.Method))
+		return
+	}
+
+	apiKey := authenticate(w, r, rc)
+	if apiKey == nil {
+		return
+	}
+
+	var completionReq CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&completionReq); err != nil {
+		log.Printf("[%s] Failed to decode request body: %v", rc.RequestID, err)
+		writeAPIError(w, rc, errInvalidRequest("Invalid request body: "+err.Error()))
+		return
+	}
+	openAIReq, extra, apiErr := completionReq.toOpenAI()
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	youModel, apiErr := mapModelName(openAIReq.Model)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	chatReq, apiErr := openAIReq.chatRequest(youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	rc.Model = openAIReq.Model
+	rc.Stream = openAIReq.Stream
+	rc.Key = apiKey
+	rc.Format = &completionsFormat{echo: completionReq.echoText()}
+	rc.Tokenizer = tokenizerFor(youModel)
+	rc.PromptTokens = rc.Tokenizer.Count(completionReq.Prompt.text())
+	rc.IncludeUsage = openAIReq.StreamOptions != nil && openAIReq.StreamOptions.IncludeUsage
+	rc.Limits = newOutputLimiter(openAIReq.Stop, openAIReq.completionLimit(), rc.Tokenizer)
+	log.Printf("[%s] Processing completions request: key=%s, model=%s, prompt=%d chars, stream=%v, user=%q",
+		rc.RequestID, apiKey.Name, openAIReq.Model, len(completionReq.Prompt.text()), openAIReq.Stream, openAIReq.User)
+	warnIgnoredParams(w, rc, &openAIReq, extra...)
+
+	serveChat(w, r, rc, chatReq)
+}
+
+// text 返回唯一的 prompt 文本，多 prompt 已在 toOpenAI 中拒绝
+func (p PromptInput) text() string {
+	if len(p) == 0 {
+		return ""
+	}
+	return p[0]
+}
+
+// echoText 返回 echo 为 true 时需要写在输出前的 prompt
+func (req *CompletionRequest) echoText() string {
+	if !req.Echo {
+		return ""
+	}
+	return req.Prompt.text()
+}
+
+// toOpenAI 将 Completions 请求转换为等价的 OpenAIRequest 并校验，同时返回被忽略的参数。
+// prompt 作为唯一的 user 消息；设置了 suffix 时通过系统提示要求模型只输出 prompt 与 suffix 之间的文本
+func (req *CompletionRequest) toOpenAI() (OpenAIRequest, []string, *APIError) {
+	if len(req.Prompt) == 0 {
+		return OpenAIRequest{}, nil, errInvalidRequest("prompt is required").withParam("prompt")
+	}
+	if len(req.Prompt) > 1 {
+		return OpenAIRequest{}, nil, errInvalidRequest("Multiple prompts are not supported by this proxy").withParam("prompt")
+	}
+	if req.Prompt[0] == "" {
+		return OpenAIRequest{}, nil, errInvalidRequest("prompt must not be empty").withParam("prompt")
+	}
+	if req.N != nil && *req.N > 1 {
+		return OpenAIRequest{}, nil, errInvalidRequest("n > 1 is only supported on /v1/chat/completions").withParam("n")
+	}
+
+	openAIReq := OpenAIRequest{
+		Model:            req.Model,
+		Stream:           req.Stream,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		MaxTokens:        req.MaxTokens,
+		Stop:             req.Stop,
+		N:                req.N,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		User:             req.User,
+		Seed:             req.Seed,
+		StreamOptions:    req.StreamOptions,
+	}
+	if req.Suffix != "" {
+		openAIReq.Messages = append(openAIReq.Messages, Message{
+			Role:    "system",
+			Content: "Continue the user's text. Output only the text that goes between the user's text and the following suffix, without repeating either:\n" + req.Suffix,
+		})
+	}
+	openAIReq.Messages = append(openAIReq.Messages, Message{Role: "user", Content: req.Prompt[0]})
+
+	if apiErr := openAIReq.validate(); apiErr != nil {
+		return openAIReq, nil, apiErr
+	}
+
+	var extra []string
+	if req.Logprobs != nil {
+		extra = append(extra, "logprobs")
+	}
+	if req.BestOf != nil {
+		extra = append(extra, "best_of")
+	}
+	return openAIReq, extra, nil
+}
+
+// completionsFormat 是旧版 Completions API 的 text_completion 格式，错误仍使用 OpenAI 格式
+type completionsFormat struct {
+	echo   string // echo 为 true 时写在输出前的 prompt
+	echoed bool   // 流式响应已经发送 echo 块
+}
+
+// chunk 构造一个流式块，finish 为空表示尚未结束
+func (f *completionsFormat) chunk(rc *requestContext, text, finish string) CompletionResponse {
+	choice := CompletionChoice{Text: text}
+	if finish != "" {
+		choice.FinishReason = &finish
+	}
+	return CompletionResponse{
+		ID:      rc.ResponseID,
+		Object:  "text_completion",
+		Created: rc.Created,
+		Model:   rc.Model,
+		Choices: []CompletionChoice{choice},
+	}
+}
+
+// writeEcho 在第一个块之前发送 prompt，重复调用无副作用
+func (f *completionsFormat) writeEcho(w http.ResponseWriter, rc *requestContext) {
+	if f.echoed || f.echo == "" {
+		return
+	}
+	f.echoed = true
+	writeSSEData(w, f.chunk(rc, f.echo, ""))
+}
+
+func (f *completionsFormat) writeDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	f.writeEcho(w, rc)
+	writeSSEData(w, f.chunk(rc, text, ""))
+	flush(w)
+}
+
+// finishStream 发送带 finish_reason 的结束块；请求了 include_usage 时再发送 usage 块，最后发送 [DONE]
+func (f *completionsFormat) finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	f.writeEcho(w, rc)
+	writeSSEData(w, f.chunk(rc, "", rc.Limits.FinishReason()))
+
+	if rc.IncludeUsage {
+		usageChunk := f.chunk(rc, "", "")
+		usageChunk.Choices = []CompletionChoice{}
+		usageChunk.Usage = rc.usage(content)
+		writeSSEData(w, usageChunk)
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flush(w)
+}
+
+func (f *completionsFormat) writeResponse(w http.ResponseWriter, rc *requestContext, content string) {
+	resp := f.chunk(rc, f.echo+content, rc.Limits.FinishReason())
+	resp.Usage = rc.usage(content)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *completionsFormat) writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	openAIFormat{}.writeError(w, rc, apiErr)
+}
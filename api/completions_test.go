@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompletionsEchoAndStop(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{" world.", " Bye"}})
+
+	body := `{"model":"gpt-3.5-turbo-instruct","prompt":"Hello","echo":true,"stop":"Bye"}`
+	req := httptest.NewRequest("POST", "/v1/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp CompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	if resp.Object != "text_completion" || !strings.HasPrefix(resp.ID, "cmpl-") {
+		t.Errorf("object = %q, id = %q", resp.Object, resp.ID)
+	}
+	if len(resp.Choices) != 1 {
+		t.Fatalf("got %d choices, want 1", len(resp.Choices))
+	}
+	choice := resp.Choices[0]
+	if choice.Text != "Hello world. " {
+		t.Errorf("text = %q, want %q", choice.Text, "Hello world. ")
+	}
+	if choice.FinishReason == nil || *choice.FinishReason != "stop" {
+		t.Errorf("finish_reason = %v, want stop", choice.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v, want completion tokens", resp.Usage)
+	}
+}
+
+func TestCompletionsStream(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"foo", "bar"}})
+
+	body := `{"model":"gpt-4o","prompt":["say foobar"],"stream":true,"max_tokens":16}`
+	req := httptest.NewRequest("POST", "/v1/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var text strings.Builder
+	var finish string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk CompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		text.WriteString(chunk.Choices[0].Text)
+		if chunk.Choices[0].FinishReason != nil {
+			finish = *chunk.Choices[0].FinishReason
+		}
+	}
+	if text.String() != "foobar" || finish != "stop" {
+		t.Errorf("text = %q, finish = %q", text.String(), finish)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+		t.Errorf("stream not terminated with [DONE]: %s", rec.Body)
+	}
+}
+
+func TestCompletionsRejectsTokenPrompts(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/completions", strings.NewReader(`{"model":"gpt-4o","prompt":[1,2,3]}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400; body = %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ContentPart 是数组形式 content 中的一个片段
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 对应 image_url 片段，URL 可以是远程地址或 data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// UnmarshalJSON 接受字符串、片段数组或 null 形式的 content
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type plain Message
+	var aux struct {
+		*plain
+		Content json.RawMessage `json:"content"`
+	}
+	aux.plain = (*plain)(m)
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+
+	m.Content, m.Parts, err = decodeContent(aux.Content)
+	return err
+}
+
+// MarshalJSON 与 OpenAI 一致，只有 tool_calls 的 assistant 消息 content 为 null
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	if m.Content != "" || len(m.ToolCalls) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content *string `json:"content"`
+	}{plain: plain(m)})
+}
+
+// decodeContent 解析字符串、片段数组或 null 形式的内容，返回 text 片段拼接后的文本和原始片段
+func decodeContent(raw json.RawMessage) (string, []ContentPart, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return "", nil, nil
+	case raw[0] == '"':
+		var text string
+		err := json.Unmarshal(raw, &text)
+		return text, nil, err
+	case raw[0] == '[':
+		var parts []ContentPart
+		if err := json.Unmarshal(raw, &parts); err != nil {
+			return "", nil, fmt.Errorf("invalid content parts: %v", err)
+		}
+		var texts []string
+		for _, part := range parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+		return strings.Join(texts, "\n"), parts, nil
+	default:
+		return "", nil, fmt.Errorf("content must be a string or an array of content parts")
+	}
+}
+
+// validateContent 检查消息中的非文本片段。
+//
+// you.com 的聊天接口只接受文本，代理没有可用的文件上传流程，图片等片段无法转发，
+// 直接返回 unsupported_content 错误，而不是静默丢弃让模型答非所问。
+func validateContent(messages []Message) *APIError {
+	for i, msg := range messages {
+		for j, part := range msg.Parts {
+			param := fmt.Sprintf("messages[%d].content[%d]", i, j)
+			switch part.Type {
+			case "text":
+			case "image_url", "image":
+				return errUnsupportedContent("Image inputs are not supported by this proxy: you.com does not accept images through the chat API").withParam(param)
+			default:
+				return errUnsupportedContent(fmt.Sprintf("Content part type %q is not supported", part.Type)).withParam(param)
+			}
+		}
+	}
+	return nil
+}
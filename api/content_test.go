@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMessageContentShapes(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantText  string
+		wantParts int
+		wantErr   bool
+	}{
+		{"string", `{"role":"user","content":"hi"}`, "hi", 0, false},
+		{"null", `{"role":"assistant","content":null}`, "", 0, false},
+		{"missing", `{"role":"assistant"}`, "", 0, false},
+		{"text parts", `{"role":"user","content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]}`, "a\nb", 2, false},
+		{"image part", `{"role":"user","content":[{"type":"text","text":"a"},{"type":"image_url","image_url":{"url":"https://example.com/x.png"}}]}`, "a", 2, false},
+		{"number", `{"role":"user","content":5}`, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			err := json.Unmarshal([]byte(tt.body), &msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if msg.Content != tt.wantText || len(msg.Parts) != tt.wantParts {
+				t.Errorf("got content %q with %d parts, want %q with %d", msg.Content, len(msg.Parts), tt.wantText, tt.wantParts)
+			}
+			if msg.Role != "user" && msg.Role != "assistant" && !tt.wantErr {
+				t.Errorf("role not decoded: %q", msg.Role)
+			}
+		})
+	}
+}
+
+func TestValidateContentRejectsImages(t *testing.T) {
+	var msg Message
+	json.Unmarshal([]byte(`{"role":"user","content":[{"type":"text","text":"a"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}`), &msg)
+
+	err := validateContent([]Message{{Role: "system", Content: "x"}, msg})
+	if err == nil || err.Code == nil || *err.Code != "unsupported_content" {
+		t.Fatalf("validateContent() = %v, want unsupported_content", err)
+	}
+	if err.Param == nil || *err.Param != "messages[1].content[1]" {
+		t.Errorf("param = %v, want messages[1].content[1]", err.Param)
+	}
+}
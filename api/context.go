@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestContext 保存单个请求的状态，在处理链中逐级传递，避免并发请求之间共享全局变量
+type requestContext struct {
+	RequestID  string // 来自 X-Request-ID 请求头，缺省时随机生成
+	Model      string // 客户端请求的模型名，原样写回响应
+	ResponseID string // chatcmpl-xxx
+	Created    int64
+	Stream     bool
+	Key        *APIKey        // 通过认证的密钥
+	Limits     *outputLimiter // 模拟 stop / max_tokens
+	Format     responseFormat // 响应格式，默认 OpenAI
+
+	Tokenizer    Tokenizer // 按模型家族估算 token
+	PromptTokens int
+	IncludeUsage bool // 流式响应结束前发送 usage 块（stream_options.include_usage）
+
+	ReasoningMode string          // 思考过程的处理方式：hidden、split 或 inline
+	Reasoning     strings.Builder // split 模式下单独输出的思考过程
+
+	CitationMode string     // 搜索来源的输出方式：off、annotations 或 markdown
+	Citations    []Citation // 上游返回的来源，按 URL 去重
+
+	YouOptions *YouOptions // 请求体中的 you_options，与 X-You-* 请求头一起在 serveChat 中合并
+
+	Tools     *toolSet   // 函数调用模拟，为空表示不解析工具调用
+	ToolCalls []ToolCall // 从回答中解析出的工具调用
+	JSON      *jsonMode  // response_format 要求的 JSON 输出，为空表示普通文本
+
+	N     int // n，大于 1 时由 serveChoices 并发生成多个 choice
+	Index int // choice 的序号，写入响应的 index
+
+	streamStarted bool // 已经写出 SSE 响应头，之后的错误只能以 SSE 事件发送
+}
+
+// newRequestContext 为一次请求创建上下文，并在响应头中写回请求 ID
+func newRequestContext(w http.ResponseWriter, r *http.Request, model string, stream bool) *requestContext {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = randomID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	return &requestContext{
+		RequestID:  requestID,
+		Model:      model,
+		ResponseID: "chatcmpl-" + randomID(),
+		Created:    time.Now().Unix(),
+		Stream:     stream,
+		Limits:     newOutputLimiter(nil, 0, cjkTokenizer),
+		Tokenizer:  cjkTokenizer,
+		Format:     openAIFormat{},
+
+		ReasoningMode: defaultReasoningMode,
+		CitationMode:  defaultCitationMode,
+	}
+}
+
+// forChoice 为 n>1 中的一个 choice 创建上下文：共享请求设置，思考过程、来源、工具调用和截断状态各自独立
+func (rc *requestContext) forChoice(index int) *requestContext {
+	return &requestContext{
+		RequestID:     rc.RequestID,
+		Model:         rc.Model,
+		ResponseID:    rc.ResponseID,
+		Created:       rc.Created,
+		Stream:        rc.Stream,
+		Key:           rc.Key,
+		Limits:        rc.Limits.fresh(),
+		Format:        rc.Format,
+		Tokenizer:     rc.Tokenizer,
+		PromptTokens:  rc.PromptTokens,
+		IncludeUsage:  rc.IncludeUsage,
+		ReasoningMode: rc.ReasoningMode,
+		CitationMode:  rc.CitationMode,
+		YouOptions:    rc.YouOptions,
+		Tools:         rc.Tools,
+		JSON:          rc.JSON,
+		Index:         index,
+	}
+}
+
+// usage 根据输出内容计算本次请求的用量，单独输出的思考过程计入 completion_tokens
+func (rc *requestContext) usage(completion string) *Usage {
+	completionTokens := rc.Tokenizer.Count(completion)
+	for _, call := range rc.ToolCalls {
+		completionTokens += rc.Tokenizer.Count(call.Function.Name) + rc.Tokenizer.Count(call.Function.Arguments)
+	}
+	usage := &Usage{PromptTokens: rc.PromptTokens}
+	if rc.Reasoning.Len() > 0 {
+		reasoningTokens := rc.Tokenizer.Count(rc.Reasoning.String())
+		completionTokens += reasoningTokens
+		usage.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: reasoningTokens}
+	}
+	usage.CompletionTokens = completionTokens
+	usage.TotalTokens = rc.PromptTokens + completionTokens
+	return usage
+}
+
+// hasOutput 表示本次请求已经得到可以返回的结果；没有任何输出时由调用方尝试备用上游
+func (rc *requestContext) hasOutput(content string) bool {
+	return content != "" || rc.Reasoning.Len() > 0 || len(rc.ToolCalls) > 0 || rc.Limits.Truncated()
+}
+
+// finishReason 返回 OpenAI 的 finish_reason，解析出工具调用时为 tool_calls
+func (rc *requestContext) finishReason() string {
+	if len(rc.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	return rc.Limits.FinishReason()
+}
+
+// randomID 生成 24 位十六进制随机字符串
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startStream 写入流式响应头，重复调用无副作用。默认为 SSE，
+// 响应格式实现了 streamContentType 时使用其返回的类型（如 Ollama 的 NDJSON）
+func (rc *requestContext) startStream(w http.ResponseWriter) {
+	if rc.streamStarted {
+		return
+	}
+	rc.streamStarted = true
+	contentType := "text/event-stream"
+	if f, ok := rc.Format.(interface{ streamContentType() string }); ok {
+		contentType = f.streamContentType()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
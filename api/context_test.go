@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHandlerConcurrentModels 并发发送不同模型的请求，检查每个响应都带着自己的模型名。
+// 使用 go test -race 运行可以发现请求之间共享的状态。
+func TestHandlerConcurrentModels(t *testing.T) {
+	withUpstreams(t, &MockUpstream{
+		Delay: time.Millisecond,
+		Reply: func(req ChatRequest) []string {
+			return []string{"model=", req.Model, " q=", req.Query}
+		},
+	})
+
+	models := []string{"gpt-4o", "claude-3.5-sonnet", "deepseek-reasoner", "gemini-1.5-pro", "o1-mini"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		model := models[i%len(models)]
+		stream := i%2 == 1
+		question := fmt.Sprintf("question-%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			body := fmt.Sprintf(`{"model":%q,"stream":%v,"messages":[{"role":"user","content":%q}]}`, model, stream, question)
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status = %d", question, rec.Code)
+				return
+			}
+
+			wantContent := "model=" + modelMap[model] + " q=" + question
+			if !stream {
+				var resp OpenAIResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Errorf("%s: decode: %v", question, err)
+					return
+				}
+				if resp.Model != model {
+					t.Errorf("%s: model = %q, want %q", question, resp.Model, model)
+				}
+				if got := resp.Choices[0].Message.Content; got != wantContent {
+					t.Errorf("%s: content = %q, want %q", question, got, wantContent)
+				}
+				return
+			}
+
+			var content strings.Builder
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok || data == "[DONE]" {
+					continue
+				}
+				var chunk OpenAIStreamResponse
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Errorf("%s: decode chunk: %v", question, err)
+					return
+				}
+				if chunk.Model != model {
+					t.Errorf("%s: chunk model = %q, want %q", question, chunk.Model, model)
+				}
+				content.WriteString(chunk.Choices[0].Delta.Content)
+			}
+			if content.String() != wantContent {
+				t.Errorf("%s: content = %q, want %q", question, content.String(), wantContent)
+			}
+		}()
+	}
+	wg.Wait()
+}
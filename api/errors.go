@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// APIError 是 OpenAI 格式的错误，序列化为 {"error":{"message","type","param","code"}}
+type APIError struct {
+	Status  int     `json:"-"`
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Type, e.Message)
+}
+
+func newAPIError(status int, errType, code, message string) *APIError {
+	e := &APIError{Status: status, Message: message, Type: errType}
+	if code != "" {
+		e.Code = &code
+	}
+	return e
+}
+
+// withParam 设置出错的请求字段名
+func (e *APIError) withParam(param string) *APIError {
+	e.Param = &param
+	return e
+}
+
+// errInvalidRequest 400，请求格式或参数错误
+func errInvalidRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, "invalid_request_error", "", message)
+}
+
+// errInvalidAPIKey 401，密钥缺失或无效
+func errInvalidAPIKey(message string) *APIError {
+	return newAPIError(http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", message)
+}
+
+// errUnsupportedContent 400，消息中包含代理无法转发的内容（如图片）
+func errUnsupportedContent(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, "invalid_request_error", "unsupported_content", message)
+}
+
+// errModelNotFound 404，模型不存在
+func errModelNotFound(model string) *APIError {
+	return newAPIError(http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("The model `%s` does not exist", model)).withParam("model")
+}
+
+// errResponseNotFound 404，响应不存在、已过期或属于其他密钥
+func errResponseNotFound(id string) *APIError {
+	return newAPIError(http.StatusNotFound, "invalid_request_error", "not_found",
+		fmt.Sprintf("Response with id '%s' not found.", id))
+}
+
+// errMethodNotAllowed 405
+func errMethodNotAllowed(method string) *APIError {
+	return newAPIError(http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed",
+		fmt.Sprintf("Method %s is not allowed for this endpoint", method))
+}
+
+// errRateLimited 429，上游限流
+func errRateLimited(message string) *APIError {
+	return newAPIError(http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded", message)
+}
+
+// errUpstream 502，上游请求失败或没有返回内容
+func errUpstream(message string) *APIError {
+	return newAPIError(http.StatusBadGateway, "upstream_error", "upstream_error", message)
+}
+
+// errInvalidJSONOutput 502，回答经过一次修复后仍不符合 response_format
+func errInvalidJSONOutput(cause error) *APIError {
+	return newAPIError(http.StatusBadGateway, "upstream_error", "json_validation_failed",
+		fmt.Sprintf("The model's reply did not match response_format after a repair attempt: %v", cause))
+}
+
+// errTimeout 504，上游超时
+func errTimeout(message string) *APIError {
+	return newAPIError(http.StatusGatewayTimeout, "timeout", "timeout", message)
+}
+
+// errInternal 500
+func errInternal(message string) *APIError {
+	return newAPIError(http.StatusInternalServerError, "server_error", "", message)
+}
+
+// errServiceUnavailable 503，服务端配置错误，暂时无法处理请求
+func errServiceUnavailable(message string) *APIError {
+	return newAPIError(http.StatusServiceUnavailable, "server_error", "service_unavailable", message)
+}
+
+// upstreamFailure 将上游返回的错误映射为对应的 APIError
+func upstreamFailure(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return errRateLimited("You.com rate limit reached, please retry later")
+		case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+			return errTimeout("You.com did not respond in time")
+		}
+		return errUpstream(fmt.Sprintf("You.com returned status %d", upstreamErr.StatusCode))
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errTimeout("Request to You.com timed out")
+	}
+	if err == nil {
+		return errUpstream("You.com returned an empty response")
+	}
+	return errUpstream("Failed to get a response from You.com")
+}
+
+// writeAPIError 按请求的响应格式写入错误，rc 为空时使用 OpenAI 格式
+func writeAPIError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	requestID := ""
+	if rc != nil {
+		requestID = rc.RequestID
+	}
+	log.Printf("[%s] Responding with error: %v", requestID, apiErr)
+
+	if rc != nil {
+		rc.Format.writeError(w, rc, apiErr)
+		return
+	}
+	openAIFormat{}.writeError(w, nil, apiErr)
+}
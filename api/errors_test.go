@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIErrorShapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *APIError
+		status int
+		body   string
+	}{
+		{
+			"invalid request with param",
+			errInvalidRequest("temperature must be between 0 and 2").withParam("temperature"),
+			http.StatusBadRequest,
+			`{"error":{"message":"temperature must be between 0 and 2","type":"invalid_request_error","param":"temperature","code":null}}`,
+		},
+		{
+			"invalid api key",
+			errInvalidAPIKey("Incorrect API key provided."),
+			http.StatusUnauthorized,
+			`{"error":{"message":"Incorrect API key provided.","type":"invalid_request_error","param":null,"code":"invalid_api_key"}}`,
+		},
+		{
+			"model not found",
+			errModelNotFound("gpt-9"),
+			http.StatusNotFound,
+			"{\"error\":{\"message\":\"The model `gpt-9` does not exist\",\"type\":\"invalid_request_error\",\"param\":\"model\",\"code\":\"model_not_found\"}}",
+		},
+		{
+			"response not found",
+			errResponseNotFound("resp_1"),
+			http.StatusNotFound,
+			`{"error":{"message":"Response with id 'resp_1' not found.","type":"invalid_request_error","param":null,"code":"not_found"}}`,
+		},
+		{
+			"upstream",
+			errUpstream("You.com returned status 500"),
+			http.StatusBadGateway,
+			`{"error":{"message":"You.com returned status 500","type":"upstream_error","param":null,"code":"upstream_error"}}`,
+		},
+		{
+			"service unavailable",
+			errServiceUnavailable("try later"),
+			http.StatusServiceUnavailable,
+			`{"error":{"message":"try later","type":"server_error","param":null,"code":"service_unavailable"}}`,
+		},
+		{
+			"internal",
+			errInternal("boom"),
+			http.StatusInternalServerError,
+			`{"error":{"message":"boom","type":"server_error","param":null,"code":null}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeAPIError(rec, nil, tt.err)
+			if rec.Code != tt.status || strings.TrimSpace(rec.Body.String()) != tt.body {
+				t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body, tt.status, tt.body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestAPIErrorAfterStreamStarted(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rc := newRequestContext(rec, httptest.NewRequest("POST", "/v1/chat/completions", nil), "gpt-4o", true)
+	rc.startStream(rec)
+
+	writeAPIError(rec, rc, errUpstream("lost connection"))
+
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "event: error\ndata: {\"error\":{\"message\":\"lost connection\"") || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("got %d %q, want an SSE error event followed by [DONE]", rec.Code, body)
+	}
+}
+
+// timeoutError 是一个超时的 net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestUpstreamFailure(t *testing.T) {
+	invalid := errInvalidRequest("bad")
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"rate limited", &UpstreamError{Upstream: "you.com", StatusCode: http.StatusTooManyRequests}, http.StatusTooManyRequests, "rate_limit_exceeded"},
+		{"gateway timeout", &UpstreamError{Upstream: "you.com", StatusCode: http.StatusGatewayTimeout}, http.StatusGatewayTimeout, "timeout"},
+		{"other status", fmt.Errorf("wrapped: %w", &UpstreamError{Upstream: "you.com", StatusCode: http.StatusForbidden}), http.StatusBadGateway, "upstream_error"},
+		{"deadline", fmt.Errorf("read: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout"},
+		{"net timeout", timeoutError{}, http.StatusGatewayTimeout, "timeout"},
+		{"empty", nil, http.StatusBadGateway, "upstream_error"},
+		{"other", errors.New("connection reset"), http.StatusBadGateway, "upstream_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := upstreamFailure(tt.err)
+			if apiErr.Status != tt.status || apiErr.Code == nil || *apiErr.Code != tt.code {
+				t.Errorf("got %+v, want %d %s", apiErr, tt.status, tt.code)
+			}
+		})
+	}
+
+	if got := upstreamFailure(fmt.Errorf("choice 1: %w", invalid)); got != invalid {
+		t.Errorf("APIError was not passed through: %+v", got)
+	}
+}
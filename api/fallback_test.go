@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postFallback 以已认证的请求调用 FallbackHandler
+func postFallback(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	FallbackHandler(rec, req)
+	return rec
+}
+
+func TestFallbackHandlerUpstreamsFail(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"unused"}},
+		&MockUpstream{Label: "a", Err: errors.New("refused")},
+		&MockUpstream{Label: "b"})
+
+	rec := postFallback(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`)
+
+	var body struct{ Error APIError }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusBadGateway || body.Error.Code == nil || *body.Error.Code != "upstream_error" {
+		t.Errorf("got %d %s, want a 502 error envelope", rec.Code, rec.Body)
+	}
+}
+
+func TestFallbackHandlerJSONMode(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"unused"}}, &MockUpstream{Tokens: []string{"Sure! ", `{"ok": true}`}})
+
+	rec := postFallback(`{"model":"gpt-4o","response_format":{"type":"json_object"},"messages":[{"role":"user","content":"hi"}]}`)
+
+	var resp OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+		t.Fatalf("invalid response %d %s: %v", rec.Code, rec.Body, err)
+	}
+	if got := resp.Choices[0].Message.Content; got != `{"ok": true}` {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestFallbackHandlerRejectsMultipleChoices(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"unused"}}, &MockUpstream{Tokens: []string{"ok"}})
+
+	rec := postFallback(`{"model":"gpt-4o","n":2,"messages":[{"role":"user","content":"hi"}]}`)
+
+	var body struct{ Error APIError }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusBadRequest || body.Error.Param == nil || *body.Error.Param != "n" {
+		t.Errorf("got %d %s, want 400 on n", rec.Code, rec.Body)
+	}
+}
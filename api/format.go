@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// responseFormat 将代理的输出编码为某个 API 的响应格式。
+// 上游调用、回退以及 stop/max_tokens 模拟由各前端共用，只有响应的写法不同
+type responseFormat interface {
+	// writeDelta 写出一段流式增量，调用前已经执行 rc.startStream
+	writeDelta(w http.ResponseWriter, rc *requestContext, text string)
+	// finishStream 写出流式响应的结束事件，content 为完整输出
+	finishStream(w http.ResponseWriter, rc *requestContext, content string)
+	// writeResponse 写出非流式响应
+	writeResponse(w http.ResponseWriter, rc *requestContext, content string)
+	// writeError 写出错误，流式响应已经开始时以 SSE 事件发送；rc 可能为空
+	writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError)
+}
+
+// openAIFormat 是 OpenAI Chat Completions 格式
+type openAIFormat struct{}
+
+func (openAIFormat) writeDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	writeSSEData(w, OpenAIStreamResponse{
+		ID:      rc.ResponseID,
+		Object:  "chat.completion.chunk",
+		Created: rc.Created,
+		Model:   rc.Model,
+		Choices: []Choice{{
+			Delta: Delta{Content: text},
+			Index: rc.Index,
+		}},
+	})
+	flush(w)
+}
+
+// writeReasoningDelta 以 delta.reasoning_content 发送思考过程，与 DeepSeek API 一致
+func (openAIFormat) writeReasoningDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	writeSSEData(w, OpenAIStreamResponse{
+		ID:      rc.ResponseID,
+		Object:  "chat.completion.chunk",
+		Created: rc.Created,
+		Model:   rc.Model,
+		Choices: []Choice{{
+			Delta: Delta{ReasoningContent: text},
+			Index: rc.Index,
+		}},
+	})
+	flush(w)
+}
+
+// writeToolCallDeltas 按 OpenAI 的流式格式发送工具调用：每个调用先发送 id、name 和空参数，再分块发送参数
+func (openAIFormat) writeToolCallDeltas(w http.ResponseWriter, rc *requestContext, calls []ToolCall) {
+	chunk := func(call ToolCall) {
+		writeSSEData(w, OpenAIStreamResponse{
+			ID:      rc.ResponseID,
+			Object:  "chat.completion.chunk",
+			Created: rc.Created,
+			Model:   rc.Model,
+			Choices: []Choice{{
+				Delta: Delta{ToolCalls: []ToolCall{call}},
+				Index: rc.Index,
+			}},
+		})
+	}
+	for i, call := range calls {
+		index := len(rc.ToolCalls) + i
+		chunk(ToolCall{Index: &index, ID: call.ID, Type: call.Type, Function: ToolCallFunction{Name: call.Function.Name}})
+		for _, args := range argumentChunks(call.Function.Arguments) {
+			chunk(ToolCall{Index: &index, Function: ToolCallFunction{Arguments: args}})
+		}
+	}
+	flush(w)
+}
+
+// finishStream 结束唯一的 choice，再结束整个响应
+func (f openAIFormat) finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	f.finishChoice(w, rc, content)
+	f.finishResponse(w, rc, rc.usage(content))
+}
+
+// finishChoice 有来源时先发送带 annotations 的块，再发送带 finish_reason 的结束块
+func (openAIFormat) finishChoice(w http.ResponseWriter, rc *requestContext, content string) {
+	if annotations := rc.annotations(content); len(annotations) > 0 {
+		writeSSEData(w, OpenAIStreamResponse{
+			ID:      rc.ResponseID,
+			Object:  "chat.completion.chunk",
+			Created: rc.Created,
+			Model:   rc.Model,
+			Choices: []Choice{{
+				Delta: Delta{Annotations: annotations},
+				Index: rc.Index,
+			}},
+			Citations: rc.citationURLs(),
+		})
+	}
+
+	writeSSEData(w, OpenAIStreamResponse{
+		ID:      rc.ResponseID,
+		Object:  "chat.completion.chunk",
+		Created: rc.Created,
+		Model:   rc.Model,
+		Choices: []Choice{{
+			Delta:        Delta{Content: ""},
+			Index:        rc.Index,
+			FinishReason: rc.finishReason(),
+		}},
+	})
+	flush(w)
+}
+
+// finishResponse 请求了 include_usage 时发送 usage 块，最后发送 [DONE]
+func (openAIFormat) finishResponse(w http.ResponseWriter, rc *requestContext, usage *Usage) {
+	if rc.IncludeUsage {
+		writeSSEData(w, OpenAIStreamResponse{
+			ID:      rc.ResponseID,
+			Object:  "chat.completion.chunk",
+			Created: rc.Created,
+			Model:   rc.Model,
+			Choices: []Choice{},
+			Usage:   usage,
+		})
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flush(w)
+}
+
+func (f openAIFormat) writeResponse(w http.ResponseWriter, rc *requestContext, content string) {
+	f.writeChoices(w, rc, []*requestContext{rc}, []string{content})
+}
+
+// writeChoices 写出包含多个 choice 的非流式响应，usage 为各 choice 之和，prompt 只计一次
+func (openAIFormat) writeChoices(w http.ResponseWriter, rc *requestContext, choices []*requestContext, contents []string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := OpenAIResponse{
+		ID:        rc.ResponseID,
+		Object:    "chat.completion",
+		Created:   rc.Created,
+		Model:     rc.Model,
+		Usage:     totalUsage(choices, contents),
+		Citations: choiceCitationURLs(choices),
+	}
+	for i, choice := range choices {
+		response.Choices = append(response.Choices, OpenAIChoice{
+			Message: Message{
+				Role:             "assistant",
+				Content:          contents[i],
+				ReasoningContent: choice.Reasoning.String(),
+				ToolCalls:        choice.ToolCalls,
+				Annotations:      choice.annotations(contents[i]),
+			},
+			Index:        choice.Index,
+			FinishReason: choice.finishReason(),
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (openAIFormat) writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	body, _ := json.Marshal(map[string]*APIError{"error": apiErr})
+	if rc != nil && rc.streamStarted {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", body)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flush(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+// writeSSEData 写出一条 data: 事件
+func writeSSEData(w http.ResponseWriter, v interface{}) {
+	if jsonData, err := json.Marshal(v); err == nil {
+		fmt.Fprintf(w, "data: %s\n\n", string(jsonData))
+	}
+}
+
+// writeSSEEvent 写出一条带事件名的 SSE 事件
+func writeSSEEvent(w http.ResponseWriter, event string, v interface{}) {
+	if jsonData, err := json.Marshal(v); err == nil {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, string(jsonData))
+	}
+}
+
+// flush 立即把已写入的数据发送给客户端
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import "strings"
+
+// buildChatHistory 将 OpenAI 消息转换为 you.com 的当前问题 q 和历史对话 chat。
+//
+// you.com 的每轮对话是一问一答：user/tool 消息属于提问一侧，assistant 属于回答一侧。
+// system/developer 消息不进入历史，由 chatRequest 提取为系统提示。
+// 同一侧的连续消息合并为一段；最后一条 assistant 之后的消息组成当前问题，不再出现在历史中。
+// 对话以 assistant 结尾时（例如客户端重新生成），最后一轮的问题作为当前问题重新提问。
+func buildChatHistory(messages []Message) (string, []ChatTurn) {
+	var turns []ChatTurn
+	var question, answer []string
+
+	for _, msg := range messages {
+		if isInstructionRole(msg.Role) {
+			continue
+		}
+		text := foldMessage(msg)
+		if text == "" {
+			continue
+		}
+		if msg.Role == "assistant" {
+			answer = append(answer, text)
+			continue
+		}
+		if len(answer) > 0 {
+			turns = append(turns, ChatTurn{
+				Question: strings.Join(question, "\n\n"),
+				Answer:   strings.Join(answer, "\n\n"),
+			})
+			question, answer = nil, nil
+		}
+		question = append(question, text)
+	}
+
+	// 以 assistant 结尾时 answer 不为空，这一轮没有进入 turns，其问题即为重新提问的当前问题
+	return strings.Join(question, "\n\n"), turns
+}
+
+// foldMessage 返回消息在 you.com 对话中的文本：tool 结果加上标注，
+// assistant 的 tool_calls 还原为模型调用工具时输出的 <tool_calls> 块
+func foldMessage(msg Message) string {
+	content := strings.TrimSpace(msg.Content)
+	switch {
+	case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+		return strings.TrimSpace(content + "\n" + renderToolCalls(msg.ToolCalls))
+	case content == "":
+		return ""
+	case (msg.Role == "tool" || msg.Role == "function") && msg.ToolCallID != "":
+		return "[Tool result for " + msg.ToolCallID + "]\n" + content
+	case msg.Role == "tool" || msg.Role == "function":
+		return "[Tool result]\n" + content
+	default:
+		return content
+	}
+}
+
+// chatRequest 根据请求构造发往 you.com 的 ChatRequest，key 用于选择密钥级的系统提示
+func (req *OpenAIRequest) chatRequest(youModel string, key *APIKey) (ChatRequest, *APIError) {
+	query, history := buildChatHistory(req.Messages)
+	if query == "" {
+		return ChatRequest{}, errInvalidRequest("messages must contain a non-empty user message").withParam("messages")
+	}
+	instructions := defaultSystemPrompts.Instructions(key, req.Messages)
+	if tools := req.toolSet(); tools != nil {
+		instructions = strings.TrimSpace(instructions + "\n\n" + tools.Prompt())
+	}
+	if req.jsonOutput != nil {
+		instructions = strings.TrimSpace(instructions + "\n\n" + req.jsonOutput.Prompt())
+	}
+	return ChatRequest{
+		Model:        youModel,
+		Query:        query,
+		History:      history,
+		Instructions: instructions,
+	}, nil
+}
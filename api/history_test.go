@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildChatHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  []Message
+		wantQuery string
+		wantTurns []ChatTurn
+	}{
+		{
+			name:      "single user message",
+			messages:  []Message{{Role: "user", Content: "hi"}},
+			wantQuery: "hi",
+		},
+		{
+			name: "pairs are zipped and current question excluded",
+			messages: []Message{
+				{Role: "user", Content: "q1"},
+				{Role: "assistant", Content: "a1"},
+				{Role: "user", Content: "q2"},
+				{Role: "assistant", Content: "a2"},
+				{Role: "user", Content: "q3"},
+			},
+			wantQuery: "q3",
+			wantTurns: []ChatTurn{{Question: "q1", Answer: "a1"}, {Question: "q2", Answer: "a2"}},
+		},
+		{
+			name: "consecutive same-role messages are merged",
+			messages: []Message{
+				{Role: "user", Content: "q1a"},
+				{Role: "user", Content: "q1b"},
+				{Role: "assistant", Content: "a1a"},
+				{Role: "assistant", Content: "a1b"},
+				{Role: "user", Content: "q2a"},
+				{Role: "user", Content: "q2b"},
+			},
+			wantQuery: "q2a\n\nq2b",
+			wantTurns: []ChatTurn{{Question: "q1a\n\nq1b", Answer: "a1a\n\na1b"}},
+		},
+		{
+			name: "system messages are left out of history",
+			messages: []Message{
+				{Role: "system", Content: "be brief"},
+				{Role: "user", Content: "q1"},
+				{Role: "assistant", Content: "a1"},
+				{Role: "developer", Content: "answer in French"},
+				{Role: "user", Content: "q2"},
+			},
+			wantQuery: "q2",
+			wantTurns: []ChatTurn{{Question: "q1", Answer: "a1"}},
+		},
+		{
+			name: "tool result belongs to the question side",
+			messages: []Message{
+				{Role: "user", Content: "weather?"},
+				{Role: "assistant", Content: "calling tool"},
+				{Role: "tool", Content: "sunny"},
+			},
+			wantQuery: "[Tool result]\nsunny",
+			wantTurns: []ChatTurn{{Question: "weather?", Answer: "calling tool"}},
+		},
+		{
+			name: "leading assistant message has empty question",
+			messages: []Message{
+				{Role: "assistant", Content: "hello, how can I help?"},
+				{Role: "user", Content: "q1"},
+			},
+			wantQuery: "q1",
+			wantTurns: []ChatTurn{{Answer: "hello, how can I help?"}},
+		},
+		{
+			name: "trailing assistant re-asks last question",
+			messages: []Message{
+				{Role: "user", Content: "q1"},
+				{Role: "assistant", Content: "a1"},
+				{Role: "user", Content: "q2"},
+				{Role: "assistant", Content: "a2"},
+			},
+			wantQuery: "q2",
+			wantTurns: []ChatTurn{{Question: "q1", Answer: "a1"}},
+		},
+		{
+			name: "empty messages are skipped",
+			messages: []Message{
+				{Role: "user", Content: "q1"},
+				{Role: "assistant", Content: "  "},
+				{Role: "user", Content: "q2"},
+			},
+			wantQuery: "q1\n\nq2",
+		},
+		{
+			name:     "only assistant messages",
+			messages: []Message{{Role: "assistant", Content: "a1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, turns := buildChatHistory(tt.messages)
+			if query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(turns, tt.wantTurns) {
+				t.Errorf("turns = %+v, want %+v", turns, tt.wantTurns)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// defaultSystemPromptTemplate 是 you.com 没有独立的指令参数时，将系统提示拼接到 q 的模板
+const defaultSystemPromptTemplate = "<instructions>\n{{system}}\n</instructions>\n\n{{query}}"
+
+// systemPrompts 是部署级的系统提示配置。
+//
+// you.com 的聊天接口没有可用的 custom instructions 参数，系统提示只能随每次请求拼接在 q 前面，
+// 不会写入历史对话。
+//
+//	DEFAULT_SYSTEM_PROMPT   部署默认的系统提示，放在请求自带的 system/developer 消息之前
+//	SYSTEM_PROMPT_TEMPLATE  拼接模板，{{system}} 替换为系统提示，{{query}} 替换为当前问题
+//
+// 密钥文件中的 system_prompt 字段会替换该密钥请求的部署默认值。
+type systemPrompts struct {
+	Default  string
+	Template string
+}
+
+// newSystemPromptsFromEnv 读取 DEFAULT_SYSTEM_PROMPT 和 SYSTEM_PROMPT_TEMPLATE
+func newSystemPromptsFromEnv() *systemPrompts {
+	sp := &systemPrompts{
+		Default:  strings.TrimSpace(os.Getenv("DEFAULT_SYSTEM_PROMPT")),
+		Template: os.Getenv("SYSTEM_PROMPT_TEMPLATE"),
+	}
+	if sp.Template == "" {
+		sp.Template = defaultSystemPromptTemplate
+	} else if !strings.Contains(sp.Template, "{{system}}") || !strings.Contains(sp.Template, "{{query}}") {
+		log.Printf("ERROR [prompt]: SYSTEM_PROMPT_TEMPLATE must contain {{system}} and {{query}}, using default")
+		sp.Template = defaultSystemPromptTemplate
+	}
+	return sp
+}
+
+// Instructions 合并部署（或密钥）的系统提示与请求中的 system/developer 消息
+func (sp *systemPrompts) Instructions(key *APIKey, messages []Message) string {
+	var parts []string
+	base := sp.Default
+	if key != nil && key.SystemPrompt != "" {
+		base = strings.TrimSpace(key.SystemPrompt)
+	}
+	if base != "" {
+		parts = append(parts, base)
+	}
+	for _, msg := range messages {
+		if isInstructionRole(msg.Role) {
+			if content := strings.TrimSpace(msg.Content); content != "" {
+				parts = append(parts, content)
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Render 按模板将系统提示拼接到当前问题前
+func (sp *systemPrompts) Render(instructions, query string) string {
+	if instructions == "" {
+		return query
+	}
+	return strings.NewReplacer("{{system}}", instructions, "{{query}}", query).Replace(sp.Template)
+}
+
+// isInstructionRole 判断消息是否为系统提示
+func isInstructionRole(role string) bool {
+	return role == "system" || role == "developer"
+}
+
+// defaultSystemPrompts 是全局系统提示配置，测试中可以替换
+var defaultSystemPrompts = newSystemPromptsFromEnv()
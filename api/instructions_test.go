@@ -0,0 +1,41 @@
+package handler
+
+import "testing"
+
+func TestSystemPromptInstructions(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "q1"},
+		{Role: "developer", Content: "answer in French"},
+	}
+	sp := &systemPrompts{Default: "deployment rules", Template: defaultSystemPromptTemplate}
+
+	tests := []struct {
+		name string
+		sp   *systemPrompts
+		key  *APIKey
+		want string
+	}{
+		{"request only", &systemPrompts{Template: defaultSystemPromptTemplate}, nil, "be brief\n\nanswer in French"},
+		{"deployment default first", sp, &APIKey{Name: "alice"}, "deployment rules\n\nbe brief\n\nanswer in French"},
+		{"key overrides default", sp, &APIKey{Name: "bob", SystemPrompt: "bob rules"}, "bob rules\n\nbe brief\n\nanswer in French"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sp.Instructions(tt.key, messages); got != tt.want {
+				t.Errorf("Instructions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemPromptRender(t *testing.T) {
+	sp := &systemPrompts{Template: "[{{system}}] {{query}}"}
+	if got := sp.Render("", "hi"); got != "hi" {
+		t.Errorf("Render without instructions = %q, want %q", got, "hi")
+	}
+	// 系统提示中的占位符不应被再次替换
+	if got, want := sp.Render("say {{query}}", "hi"), "[say {{query}}] hi"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ResponseFormat 对应 response_format：text、json_object 或 json_schema
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat 是 json_schema 类型的 schema 定义
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// jsonMode 是一次请求中生效的 JSON 输出要求。you.com 不支持约束解码，代理在系统提示中要求模型只输出 JSON，
+// 回答结束后去掉代码块标记并校验，不合格时带上错误原因重新询问一次
+type jsonMode struct {
+	Name   string
+	Schema *jsonSchema     // json_object 时为空，只要求是 JSON 对象
+	Raw    json.RawMessage // 原始 schema，写入提示
+}
+
+// validateResponseFormat 检查 response_format 并返回生效的 JSON 要求，text 或未设置时返回 nil
+func (req *OpenAIRequest) validateResponseFormat() (*jsonMode, *APIError) {
+	if req.ResponseFormat == nil {
+		return nil, nil
+	}
+	switch req.ResponseFormat.Type {
+	case "text":
+		return nil, nil
+	case "json_object":
+		return &jsonMode{}, nil
+	case "json_schema":
+	default:
+		return nil, errInvalidRequest("response_format.type must be one of text, json_object or json_schema").withParam("response_format.type")
+	}
+
+	format := req.ResponseFormat.JSONSchema
+	if format == nil {
+		return nil, errInvalidRequest("response_format.json_schema is required when type is json_schema").withParam("response_format.json_schema")
+	}
+	if !toolNamePattern.MatchString(format.Name) {
+		return nil, errInvalidRequest("response_format.json_schema.name must be 1-64 characters of a-z, A-Z, 0-9, underscores and dashes").withParam("response_format.json_schema.name")
+	}
+	mode := &jsonMode{Name: format.Name, Raw: format.Schema}
+	if len(format.Schema) == 0 {
+		return mode, nil
+	}
+	schema, err := compileJSONSchema(format.Schema)
+	if err != nil {
+		return nil, errInvalidRequest(fmt.Sprintf("Invalid schema for response_format '%s': %v", format.Name, err)).withParam("response_format.json_schema.schema")
+	}
+	mode.Schema = schema
+	return mode, nil
+}
+
+// enableJSON 启用 JSON 输出要求，mode 为空时不做任何事。
+// 回答中的 <think> 和 Markdown 来源列表会破坏 JSON，改为单独返回
+func (rc *requestContext) enableJSON(mode *jsonMode) {
+	rc.JSON = mode
+	if mode == nil {
+		return
+	}
+	if rc.ReasoningMode == reasoningInline {
+		rc.ReasoningMode = reasoningSplit
+	}
+	if rc.CitationMode == citationsMarkdown {
+		rc.CitationMode = citationsAnnotations
+	}
+}
+
+// Prompt 返回写入系统提示的输出要求
+func (m *jsonMode) Prompt() string {
+	if m.Schema == nil {
+		return "Respond only with a single valid JSON object. Do not wrap it in a code fence and do not add any text before or after it."
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, m.Raw)
+	return "Respond only with a single valid JSON value that conforms to this JSON Schema:\n" + compact.String() +
+		"\nDo not wrap it in a code fence and do not add any text before or after it."
+}
+
+// Check 去掉回答外围的代码块标记和多余文本，返回校验通过的 JSON
+func (m *jsonMode) Check(content string) (string, error) {
+	text := jsonCandidate(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "", fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+	if m.Schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("reply must be a JSON object, got %s", jsonTypeName(value))
+		}
+		return text, nil
+	}
+	if err := m.Schema.Validate(value); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// jsonCandidate 去掉 ```json 代码块标记；仍然不是合法 JSON 时，取第一个 { 或 [ 到最后一个 } 或 ] 之间的部分
+func jsonCandidate(content string) string {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		if nl := strings.IndexByte(text, '\n'); nl >= 0 {
+			text = text[nl+1:]
+		} else {
+			text = strings.TrimPrefix(text, "```")
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	if json.Valid([]byte(text)) {
+		return text
+	}
+	start := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if start >= 0 && end > start && json.Valid([]byte(text[start:end+1])) {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// repairQuery 是校验失败后重新询问的问题
+func repairQuery(err error) string {
+	return fmt.Sprintf("Your previous reply did not meet the required format: %v\n"+
+		"Reply again with only the corrected JSON, without a code fence or any other text.", err)
+}
+
+// conformJSON 校验回答，不合格时带上错误原因重新询问一次，仍不合格时返回错误
+func conformJSON(ctx context.Context, rc *requestContext, req ChatRequest, content string) (string, *APIError) {
+	text, err := rc.JSON.Check(content)
+	if err == nil {
+		return text, nil
+	}
+	log.Printf("[%s] Reply failed JSON validation, asking for a repair: %v", rc.RequestID, err)
+
+	repair := req
+	repair.History = append(append([]ChatTurn(nil), req.History...), ChatTurn{Question: req.Query, Answer: content})
+	repair.Query = repairQuery(err)
+	repaired, upstreamErr := askOnce(ctx, repair)
+	if repaired == "" {
+		log.Printf("[%s] Repair request returned no content: %v", rc.RequestID, upstreamErr)
+		return "", errInvalidJSONOutput(err)
+	}
+	repaired = stripThink(repaired)
+
+	text, err = rc.JSON.Check(repaired)
+	if err != nil {
+		log.Printf("[%s] Repaired reply still failed JSON validation: %v", rc.RequestID, err)
+		return "", errInvalidJSONOutput(err)
+	}
+	return text, nil
+}
+
+// askOnce 向主上游发送一次请求并收集全部回答，失败时依次尝试备用上游
+func askOnce(ctx context.Context, req ChatRequest) (string, error) {
+	events, err := primaryUpstream.Stream(ctx, req)
+	if err == nil {
+		var content string
+		content, err = collectText(events)
+		if content != "" {
+			return content, nil
+		}
+	}
+	return tryMultipleMethods(ctx, req)
+}
+
+// conformJSONEvents 收集上游事件流中的全部回答并校验，返回重放校验后内容的事件流。
+// 没有回答（交给备用上游处理）或已经解析出工具调用时原样重放
+func conformJSONEvents(ctx context.Context, rc *requestContext, req ChatRequest, events <-chan Event) (<-chan Event, *APIError) {
+	var (
+		content  strings.Builder
+		buffered []Event
+		hasCalls bool
+	)
+	for ev := range events {
+		content.WriteString(ev.Text)
+		hasCalls = hasCalls || len(ev.ToolCalls) > 0
+		buffered = append(buffered, ev)
+	}
+
+	if content.Len() > 0 && !hasCalls {
+		text, apiErr := conformJSON(ctx, rc, req, content.String())
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		// 保留思考过程和来源，回答替换为校验后的 JSON；修复成功后上游中途的错误不再有意义
+		kept := buffered[:0]
+		for _, ev := range buffered {
+			ev.Text, ev.Err = "", nil
+			if ev.Reasoning != "" || len(ev.Citations) > 0 {
+				kept = append(kept, ev)
+			}
+		}
+		buffered = append(kept, Event{Text: text})
+	}
+
+	out := make(chan Event, len(buffered))
+	for _, ev := range buffered {
+		out <- ev
+	}
+	close(out)
+	return out, nil
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const personFormat = `"response_format":{"type":"json_schema","json_schema":{"name":"person","schema":{"type":"object","properties":{"name":{"type":"string"},"age":{"type":"integer","minimum":0}},"required":["name","age"],"additionalProperties":false}}}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := compileJSONSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+			"kind": {"enum": ["a", "b"]},
+			"score": {"type": ["number", "null"], "exclusiveMaximum": 1}
+		},
+		"required": ["kind"],
+		"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{`{"kind":"a","tags":["x","y"],"score":null}`, ""},
+		{`{"tags":[]}`, `missing required property "kind"`},
+		{`{"kind":"c"}`, "$.kind: must be one of"},
+		{`{"kind":"a","tags":["ok","Bad"]}`, "$.tags[1]: must match pattern"},
+		{`{"kind":"a","tags":["a","b","c"]}`, "$.tags: must have at most 2 items"},
+		{`{"kind":"a","score":1}`, "$.score: must be < 1"},
+		{`[]`, "$: expected object, got array"},
+	}
+	for _, tt := range tests {
+		var value interface{}
+		json.Unmarshal([]byte(tt.value), &value)
+		err := schema.Validate(value)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.value, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: got %v, want %q", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestJSONSchemaConst(t *testing.T) {
+	tests := []struct {
+		schema, value string
+		valid         bool
+	}{
+		{`{"const":null}`, `null`, true},
+		{`{"const":null}`, `0`, false},
+		{`{"const":{"a":[1]}}`, `{"a":[1]}`, true},
+		{`{"const":{"a":[1]}}`, `{"a":[2]}`, false},
+		{`{}`, `"anything"`, true},
+	}
+	for _, tt := range tests {
+		schema, err := compileJSONSchema(json.RawMessage(tt.schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value interface{}
+		json.Unmarshal([]byte(tt.value), &value)
+		if err := schema.Validate(value); (err == nil) != tt.valid {
+			t.Errorf("%s against %s: err = %v, want valid = %v", tt.value, tt.schema, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateCarriesJSONMode(t *testing.T) {
+	var req OpenAIRequest
+	if err := json.Unmarshal([]byte(`{"messages":[{"role":"user","content":"hi"}],`+personFormat+`}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if req.jsonOutput == nil || req.jsonOutput.Name != "person" || req.jsonOutput.Schema == nil {
+		t.Fatalf("jsonOutput = %+v, want the compiled person schema", req.jsonOutput)
+	}
+	chatReq, apiErr := req.chatRequest("gpt_4o", nil)
+	if apiErr != nil || !strings.Contains(chatReq.Instructions, `"additionalProperties":false`) {
+		t.Errorf("instructions = %q, %v; want the schema prompt", chatReq.Instructions, apiErr)
+	}
+}
+
+func TestJSONCandidate(t *testing.T) {
+	tests := map[string]string{
+		"```json\n{\"a\":1}\n```":       `{"a":1}`,
+		"Here you go: {\"a\":1} Enjoy!": `{"a":1}`,
+		"  [1, 2]  ":                    `[1, 2]`,
+		"```\n{\"a\":\"```\"}\n```":     `{"a":"` + "```" + `"}`,
+		"not json":                      "not json",
+	}
+	for in, want := range tests {
+		if got := jsonCandidate(in); got != want {
+			t.Errorf("jsonCandidate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChatJSONSchemaRepair(t *testing.T) {
+	var queries []string
+	withUpstreams(t, &MockUpstream{Reply: func(req ChatRequest) []string {
+		queries = append(queries, req.Query)
+		if len(queries) == 1 {
+			return []string{"```json\n", `{"name":"Ada","age":"36"}`, "\n```"}
+		}
+		return []string{"```json\n", `{"name":"Ada","age":36}`, "\n```"}
+	}})
+
+	body := `{"model":"gpt-4o",` + personFormat + `,"messages":[{"role":"user","content":"Ada, 36"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var resp OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	if got := resp.Choices[0].Message.Content; got != `{"name":"Ada","age":36}` {
+		t.Errorf("content = %q", got)
+	}
+	if len(queries) != 2 || !strings.Contains(queries[1], "$.age: expected integer, got string") {
+		t.Errorf("queries = %q, want one repair naming the failed field", queries)
+	}
+}
+
+func TestChatJSONSchemaStillInvalid(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"I cannot answer that."}})
+
+	body := `{"model":"gpt-4o","stream":true,` + personFormat + `,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "json_validation_failed") {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChatJSONObjectStream(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Tokens: []string{"Sure! ", `{"ok":`, ` true}`}})
+
+	body := `{"model":"gpt-4o","stream":true,"response_format":{"type":"json_object"},"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var content strings.Builder
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, c := range chunk.Choices {
+			content.WriteString(c.Delta.Content)
+		}
+	}
+	if content.String() != `{"ok": true}` {
+		t.Errorf("content = %q", content.String())
+	}
+}
+
+func TestResponseFormatValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		param string
+	}{
+		{"unknown type", `{"response_format":{"type":"xml"}}`, "response_format.type"},
+		{"missing schema", `{"response_format":{"type":"json_schema"}}`, "response_format.json_schema"},
+		{"bad name", `{"response_format":{"type":"json_schema","json_schema":{"name":"a b","schema":{}}}}`, "response_format.json_schema.name"},
+		{"bad pattern", `{"response_format":{"type":"json_schema","json_schema":{"name":"x","schema":{"pattern":"("}}}}`, "response_format.json_schema.schema"},
+		{"bad ref", `{"response_format":{"type":"json_schema","json_schema":{"name":"x","schema":{"$ref":"#/$defs/missing"}}}}`, "response_format.json_schema.schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			_, apiErr := req.validateResponseFormat()
+			if apiErr == nil || apiErr.Param == nil || *apiErr.Param != tt.param {
+				t.Errorf("got %v, want error on %s", apiErr, tt.param)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema 是 JSON Schema 中结构化输出常用的子集：type、enum、const、properties、required、
+// additionalProperties、items、数值和长度范围、pattern、anyOf/oneOf/allOf 以及指向 $defs/definitions 的 $ref。
+// 其他关键字（format 等）被忽略
+type jsonSchema struct {
+	Type                 jsonTypes              `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                json.RawMessage        `json:"const"` // 保留原文，以区分 "const": null 和未设置
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchemaOrBool      `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+	AllOf                []*jsonSchema          `json:"allOf"`
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+
+	pattern    *regexp.Regexp
+	constValue interface{} // 解析后的 Const
+}
+
+// jsonTypes 对应 type，可以是字符串或字符串数组
+type jsonTypes []string
+
+func (t *jsonTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = jsonTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = list
+	return nil
+}
+
+// jsonSchemaOrBool 对应 additionalProperties，可以是布尔值或 schema
+type jsonSchemaOrBool struct {
+	Allowed bool
+	Schema  *jsonSchema
+}
+
+func (s *jsonSchemaOrBool) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &s.Allowed) == nil {
+		return nil
+	}
+	s.Allowed = true
+	return json.Unmarshal(data, &s.Schema)
+}
+
+// compileJSONSchema 解析 schema 并预编译其中的正则表达式
+func compileJSONSchema(raw json.RawMessage) (*jsonSchema, error) {
+	var root jsonSchema
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, err
+	}
+	var compile func(s *jsonSchema) error
+	compile = func(s *jsonSchema) error {
+		if s == nil {
+			return nil
+		}
+		if s.Const != nil {
+			if err := json.Unmarshal(s.Const, &s.constValue); err != nil {
+				return fmt.Errorf("invalid const: %v", err)
+			}
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+			}
+			s.pattern = re
+		}
+		if s.Ref != "" && root.resolve(s.Ref) == nil {
+			return fmt.Errorf("unresolvable $ref %q", s.Ref)
+		}
+		children := []*jsonSchema{s.Items}
+		children = append(children, s.AnyOf...)
+		children = append(children, s.OneOf...)
+		children = append(children, s.AllOf...)
+		for _, m := range []map[string]*jsonSchema{s.Properties, s.Defs, s.Definitions} {
+			for _, child := range m {
+				children = append(children, child)
+			}
+		}
+		if s.AdditionalProperties != nil {
+			children = append(children, s.AdditionalProperties.Schema)
+		}
+		for _, child := range children {
+			if err := compile(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := compile(&root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// resolve 查找 #、#/$defs/name 或 #/definitions/name 形式的本地引用
+func (s *jsonSchema) resolve(ref string) *jsonSchema {
+	switch {
+	case ref == "#":
+		return s
+	case strings.HasPrefix(ref, "#/$defs/"):
+		return s.Defs[strings.TrimPrefix(ref, "#/$defs/")]
+	case strings.HasPrefix(ref, "#/definitions/"):
+		return s.Definitions[strings.TrimPrefix(ref, "#/definitions/")]
+	}
+	return nil
+}
+
+// Validate 校验已解析的 JSON 值，返回第一个不符合的位置和原因
+func (s *jsonSchema) Validate(value interface{}) error {
+	return s.validate(s, value, "$", 0)
+}
+
+// maxSchemaDepth 防止递归引用导致无限循环
+const maxSchemaDepth = 64
+
+func (s *jsonSchema) validate(root *jsonSchema, value interface{}, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nesting too deep", path)
+	}
+	if s.Ref != "" {
+		if err := root.resolve(s.Ref).validate(root, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Type) > 0 {
+		matched := false
+		for _, t := range s.Type {
+			matched = matched || jsonTypeMatches(t, value)
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonTypeName(value))
+		}
+	}
+	if s.Const != nil && !jsonEqual(s.constValue, value) {
+		return fmt.Errorf("%s: must equal %s", path, jsonString(s.constValue))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || jsonEqual(e, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %s", path, jsonString(s.Enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(root, v, path, depth); err != nil {
+			return err
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s: must have at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(root, item, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: must match pattern %s", path, s.Pattern)
+		}
+	case float64:
+		switch {
+		case s.Minimum != nil && v < *s.Minimum:
+			return fmt.Errorf("%s: must be >= %g", path, *s.Minimum)
+		case s.Maximum != nil && v > *s.Maximum:
+			return fmt.Errorf("%s: must be <= %g", path, *s.Maximum)
+		case s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum:
+			return fmt.Errorf("%s: must be > %g", path, *s.ExclusiveMinimum)
+		case s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum:
+			return fmt.Errorf("%s: must be < %g", path, *s.ExclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		if err := sub.validate(root, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		var firstErr error
+		for _, sub := range s.AnyOf {
+			err := sub.validate(root, value, path, depth+1)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: does not match any of anyOf (%v)", path, firstErr)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(root, value, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: must match exactly one of oneOf, matched %d", path, matches)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(root *jsonSchema, obj map[string]interface{}, path string, depth int) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	// 按名称排序，使错误信息稳定
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		childPath := path + "." + name
+		if prop, ok := s.Properties[name]; ok {
+			if err := prop.validate(root, obj[name], childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.Allowed {
+			return fmt.Errorf("%s: unexpected property %q", path, name)
+		}
+		if extra := s.AdditionalProperties.Schema; extra != nil {
+			if err := extra.validate(root, obj[name], childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonTypeMatches 判断 encoding/json 解析出的值是否属于 JSON Schema 类型
+func jsonTypeMatches(t string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	for _, t := range []string{"null", "boolean", "string", "integer", "number", "array", "object"} {
+		if jsonTypeMatches(t, value) {
+			return t
+		}
+	}
+	return "unknown"
+}
+
+// jsonEqual 比较两个 JSON 值是否相等
+func jsonEqual(a, b interface{}) bool {
+	return jsonString(a) == jsonString(b)
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// outputLimiter 在代理侧模拟 stop 和 max_tokens：you.com 不支持这两个参数，
+// 因此由代理截断输出，并给出对应的 finish_reason
+type outputLimiter struct {
+	stop      []string
+	maxTokens int // 0 表示不限制
+	tokenizer Tokenizer
+
+	emitted TokenCounter // 已经输出的 token 数，增量累计，避免每次重新计算整个输出
+	held    string       // 可能是某个 stop 序列开头的尾部文本，暂不输出
+	finish  string       // 截断原因，未截断时为空
+	matched string       // 命中的 stop 序列
+}
+
+func newOutputLimiter(stop []string, maxTokens int, tokenizer Tokenizer) *outputLimiter {
+	return &outputLimiter{stop: stop, maxTokens: maxTokens, tokenizer: tokenizer, emitted: tokenizer.Counter()}
+}
+
+// fresh 返回设置相同、尚未输出任何内容的 limiter
+func (l *outputLimiter) fresh() *outputLimiter {
+	return newOutputLimiter(l.stop, l.maxTokens, l.tokenizer)
+}
+
+// Push 输入上游增量，返回可以输出的文本；done 为 true 时已经截断，调用方应停止读取上游
+func (l *outputLimiter) Push(text string) (out string, done bool) {
+	if l.finish != "" {
+		return "", true
+	}
+
+	buf := l.held + text
+	l.held = ""
+
+	if idx, seq := l.findStop(buf); idx >= 0 {
+		out, _ = l.limitTokens(buf[:idx])
+		if l.finish == "" {
+			l.finish = "stop"
+			l.matched = seq
+		}
+		return out, true
+	}
+
+	// 保留可能与后续文本拼成 stop 序列的尾部
+	if keep := l.partialStopSuffix(buf); keep > 0 {
+		l.held = buf[len(buf)-keep:]
+		buf = buf[:len(buf)-keep]
+	}
+
+	out, done = l.limitTokens(buf)
+	if done {
+		l.held = ""
+	}
+	return out, done
+}
+
+// Flush 在上游结束时输出暂存的文本
+func (l *outputLimiter) Flush() string {
+	if l.finish != "" {
+		return ""
+	}
+	out, _ := l.limitTokens(l.held)
+	l.held = ""
+	return out
+}
+
+// Apply 一次性处理完整文本，用于备用上游返回的整段内容
+func (l *outputLimiter) Apply(content string) string {
+	out, done := l.Push(content)
+	if !done {
+		out += l.Flush()
+	}
+	return out
+}
+
+// Truncated 表示输出因 stop 或 max_tokens 被截断
+func (l *outputLimiter) Truncated() bool {
+	return l.finish != ""
+}
+
+// FinishReason 返回 OpenAI 的 finish_reason
+func (l *outputLimiter) FinishReason() string {
+	if l.finish != "" {
+		return l.finish
+	}
+	return "stop"
+}
+
+// StopSequence 返回命中的 stop 序列，因 max_tokens 截断或未截断时为空
+func (l *outputLimiter) StopSequence() string {
+	return l.matched
+}
+
+// findStop 返回最早出现的 stop 序列及其位置，没有时返回 -1
+func (l *outputLimiter) findStop(s string) (int, string) {
+	first, matched := -1, ""
+	for _, seq := range l.stop {
+		if idx := strings.Index(s, seq); idx >= 0 && (first < 0 || idx < first) {
+			first, matched = idx, seq
+		}
+	}
+	return first, matched
+}
+
+// partialStopSuffix 返回 s 末尾与某个 stop 序列开头相同的最长字节数
+func (l *outputLimiter) partialStopSuffix(s string) int {
+	longest := 0
+	for _, seq := range l.stop {
+		for n := min(len(seq)-1, len(s)); n > longest; n-- {
+			if strings.HasSuffix(s, seq[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// limitTokens 输出 s 中不超过 max_tokens 的部分
+func (l *outputLimiter) limitTokens(s string) (string, bool) {
+	if l.maxTokens <= 0 || s == "" {
+		return s, false
+	}
+
+	// within 返回已输出内容加上 s 的前 n 字节后是否仍不超过上限
+	within := func(n int) bool {
+		c := l.emitted.Clone()
+		c.Add(s[:n])
+		return c.Total() <= l.maxTokens
+	}
+	if within(len(s)) {
+		l.emitted.Add(s)
+		return s, false
+	}
+
+	// 二分查找不超过上限的最长前缀（按字符边界）
+	bounds := make([]int, 0, len(s)+1)
+	for i := range s {
+		bounds = append(bounds, i)
+	}
+	bounds = append(bounds, len(s))
+	k := sort.Search(len(bounds), func(i int) bool {
+		return !within(bounds[i])
+	}) - 1
+
+	out := s[:bounds[max(k, 0)]]
+	l.emitted.Add(out)
+	l.finish = "length"
+	return out, true
+}
+
+// limitEvents 包装上游事件流，按 limiter 截断；截断后调用 cancel 停止上游读取
+func limitEvents(events <-chan Event, limiter *outputLimiter, cancel context.CancelFunc) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for ev := range events {
+			if ev.Err != nil || ev.Text == "" {
+				out <- ev
+				continue
+			}
+			text, done := limiter.Push(ev.Text)
+			if text != "" || ev.Reasoning != "" || len(ev.Citations) > 0 || len(ev.ToolCalls) > 0 {
+				ev.Text = text
+				out <- ev
+			}
+			if done {
+				cancel()
+				for range events {
+				}
+				return
+			}
+		}
+		if rest := limiter.Flush(); rest != "" {
+			out <- Event{Text: rest}
+		}
+	}()
+	return out
+}
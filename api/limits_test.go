@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOutputLimiterStopAcrossChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		stop     []string
+		chunks   []string
+		want     []string // 每次 Push 的输出，最后一项是 Flush 的输出
+		wantStop string
+	}{
+		{"split stop", []string{"STOP"}, []string{"Hel", "lo ST", "OP world"}, []string{"Hel", "lo ", "", ""}, "STOP"},
+		{"held prefix released", []string{"STOP"}, []string{"abc ST", "ay"}, []string{"abc ", "STay", ""}, ""},
+		{"held prefix flushed", []string{"STOP"}, []string{"x ST"}, []string{"x ", "ST"}, ""},
+		{"earliest of several", []string{"\n\n", "END"}, []string{"a EN", "D\n\nb"}, []string{"a ", "", ""}, "END"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newOutputLimiter(tt.stop, 0, cl100kTokenizer)
+			var got []string
+			for _, chunk := range tt.chunks {
+				out, _ := l.Push(chunk)
+				got = append(got, out)
+			}
+			got = append(got, l.Flush())
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("outputs = %q, want %q", got, tt.want)
+			}
+			if l.StopSequence() != tt.wantStop || l.Truncated() != (tt.wantStop != "") {
+				t.Errorf("stop = %q, truncated = %v", l.StopSequence(), l.Truncated())
+			}
+		})
+	}
+}
+
+func TestOutputLimiterMaxTokens(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog, 1234567 times! 你好世界，今天天气很好。"
+	for _, maxTokens := range []int{1, 3, 7, 12} {
+		whole := newOutputLimiter(nil, maxTokens, cl100kTokenizer).Apply(text)
+
+		// 分块输入，块边界落在单词和多字节字符中间，结果应与一次输入相同
+		l := newOutputLimiter(nil, maxTokens, cl100kTokenizer)
+		var chunked strings.Builder
+		for _, chunk := range []string{"The qu", "ick brown fox j", "umps over the lazy dog, 1234", "567 times! 你好", "世界，今天天气很好。"} {
+			out, done := l.Push(chunk)
+			chunked.WriteString(out)
+			if done {
+				break
+			}
+		}
+		if chunked.String() != whole {
+			t.Errorf("max_tokens=%d: chunked output %q differs from %q", maxTokens, chunked.String(), whole)
+		}
+		if !l.Truncated() || l.FinishReason() != "length" {
+			t.Errorf("max_tokens=%d: truncated = %v, finish = %q", maxTokens, l.Truncated(), l.FinishReason())
+		}
+
+		// 输出是不超过上限的最长前缀
+		if n := cl100kTokenizer.Count(whole); n > maxTokens {
+			t.Errorf("max_tokens=%d: output %q has %d tokens", maxTokens, whole, n)
+		}
+		rest := strings.TrimPrefix(text, whole)
+		_, size := utf8.DecodeRuneInString(rest)
+		if cl100kTokenizer.Count(whole+rest[:size]) <= maxTokens {
+			t.Errorf("max_tokens=%d: output %q could be longer", maxTokens, whole)
+		}
+	}
+
+	l := newOutputLimiter(nil, 100, cl100kTokenizer)
+	if out := l.Apply(text); out != text || l.Truncated() || l.FinishReason() != "stop" {
+		t.Errorf("under the limit: out = %q, truncated = %v", out, l.Truncated())
+	}
+}
+
+func TestLimitEventsKeepsMetadata(t *testing.T) {
+	events := make(chan Event, 2)
+	events <- Event{Text: "hello", Citations: []Citation{{URL: "https://example.com"}}, ToolCalls: []ToolCall{{ID: "call_1"}}}
+	events <- Event{Text: " world STOP tail"}
+	close(events)
+
+	var got []Event
+	for ev := range limitEvents(events, newOutputLimiter([]string{"STOP"}, 0, cl100kTokenizer), func() {}) {
+		got = append(got, ev)
+	}
+	if len(got) != 2 || got[0].Text != "hello" || len(got[0].Citations) != 1 || len(got[0].ToolCalls) != 1 || got[1].Text != " world " {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+func TestLimitEventsCancelsOnTruncation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := (&MockUpstream{Tokens: []string{"one ", "two ", "three ", "four "}}).Stream(ctx, ChatRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	for ev := range limitEvents(events, newOutputLimiter(nil, 2, cl100kTokenizer), cancel) {
+		out.WriteString(ev.Text)
+	}
+	if out.String() != "one two " || ctx.Err() == nil {
+		t.Errorf("out = %q, upstream cancelled = %v", out.String(), ctx.Err() != nil)
+	}
+}
+
+func TestChatFinishReasonLength(t *testing.T) {
+	tests := []struct {
+		params      string
+		wantContent string
+		wantFinish  string
+	}{
+		{`"max_tokens":2`, "one two ", "length"},
+		{`"stop":["thr"]`, "one two ", "stop"},
+		{`"max_tokens":50`, "one two three four ", "stop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"one ", "two ", "three ", "four "}})
+
+			body := `{"model":"gpt-4o",` + tt.params + `,"messages":[{"role":"user","content":"count"}]}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			var resp OpenAIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+				t.Fatalf("invalid response %s: %v", rec.Body, err)
+			}
+			if c := resp.Choices[0]; c.Message.Content != tt.wantContent || c.FinishReason != tt.wantFinish {
+				t.Errorf("content = %q, finish_reason = %q", c.Message.Content, c.FinishReason)
+			}
+		})
+	}
+}
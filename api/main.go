@@ -2,20 +2,20 @@ package handler
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
-)
 
-type YouChatResponse struct {
-	YouChatToken string `json:"youChatToken"`
-}
+	"you2api/internal/sse"
+)
 
 type OpenAIStreamResponse struct {
 	ID      string   `json:"id"`
@@ -23,6 +23,10 @@ type OpenAIStreamResponse struct {
 	Created int64    `json:"created"`
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+
+	// Citations 是扩展字段，列出搜索来源的 URL
+	Citations []string `json:"citations,omitempty"`
 }
 
 type Choice struct {
@@ -32,18 +36,55 @@ type Choice struct {
 }
 
 type Delta struct {
-	Content string `json:"content"`
+	Content          string       `json:"content"`
+	ReasoningContent string       `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall   `json:"tool_calls,omitempty"`
+	Annotations      []Annotation `json:"annotations,omitempty"`
 }
 
 type OpenAIRequest struct {
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
-	Model    string    `json:"model"`
+	Messages            []Message      `json:"messages"`
+	Stream              bool           `json:"stream"`
+	Model               string         `json:"model"`
+	Temperature         *float64       `json:"temperature,omitempty"`
+	TopP                *float64       `json:"top_p,omitempty"`
+	MaxTokens           *int           `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int           `json:"max_completion_tokens,omitempty"`
+	Stop                StopSequences  `json:"stop,omitempty"`
+	N                   *int           `json:"n,omitempty"`
+	PresencePenalty     *float64       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64       `json:"frequency_penalty,omitempty"`
+	User                string         `json:"user,omitempty"`
+	Seed                *int64         `json:"seed,omitempty"`
+	StreamOptions       *StreamOptions `json:"stream_options,omitempty"`
+
+	// ReasoningMode 是扩展参数，控制推理模型思考过程的输出方式：hidden、split 或 inline
+	ReasoningMode string `json:"reasoning_mode,omitempty"`
+	// CitationMode 是扩展参数，控制搜索来源的输出方式：off、annotations 或 markdown
+	CitationMode string `json:"citation_mode,omitempty"`
+	// YouOptions 是扩展参数，覆盖 you.com 的搜索和模式设置
+	YouOptions *YouOptions `json:"you_options,omitempty"`
+
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
+	Tools             []Tool          `json:"tools,omitempty"`
+	ToolChoice        *ToolChoice     `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+
+	// jsonOutput 是 validate 根据 ResponseFormat 编译出的 JSON 要求，schema 每个请求只编译一次
+	jsonOutput *jsonMode
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role             string       `json:"role"`
+	Content          string       `json:"content"`
+	ReasoningContent string       `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall   `json:"tool_calls,omitempty"`
+	ToolCallID       string       `json:"tool_call_id,omitempty"`
+	Name             string       `json:"name,omitempty"`
+	Annotations      []Annotation `json:"annotations,omitempty"`
+
+	// Parts 是数组形式 content 的原始片段，Content 为其中 text 片段拼接后的文本
+	Parts []ContentPart `json:"-"`
 }
 
 type OpenAIResponse struct {
@@ -52,6 +93,10 @@ type OpenAIResponse struct {
 	Created int64          `json:"created"`
 	Model   string         `json:"model"`
 	Choices []OpenAIChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+
+	// Citations 是扩展字段，列出搜索来源的 URL
+	Citations []string `json:"citations,omitempty"`
 }
 
 type OpenAIChoice struct {
@@ -94,23 +139,11 @@ func getReverseModelMap() map[string]string {
 	return reverse
 }
 
-func mapModelName(openAIModel string) string {
-	if mappedModel, exists := modelMap[openAIModel]; exists {
-		return mappedModel
-	}
-	return "deepseek_v3"
-}
-
-func reverseMapModelName(youModel string) string {
-	reverseMap := getReverseModelMap()
-	if mappedModel, exists := reverseMap[youModel]; exists {
-		return mappedModel
-	}
-	return "deepseek-chat"
+// mapModelName 将请求中的模型名解析为 you.com 模型 ID，规则见 modelResolver
+func mapModelName(openAIModel string) (string, *APIError) {
+	return defaultModelResolver.Resolve(openAIModel)
 }
 
-var originalModel string
-
 func Handler(w http.ResponseWriter, r *http.Request) {
 	// Handle test endpoint first
 	if r.URL.Path == "/test" || r.URL.Path == "/test/" {
@@ -118,6 +151,40 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == "/v1/models" || strings.HasPrefix(r.URL.Path, "/v1/models/") {
+		setCORSHeaders(w)
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		ModelsHandler(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		setCORSHeaders(w)
+		OllamaHandler(w, r)
+		return
+	}
+
+	if r.URL.Path == "/v1/messages" {
+		setCORSHeaders(w)
+		AnthropicHandler(w, r)
+		return
+	}
+
+	if r.URL.Path == "/v1/responses" || strings.HasPrefix(r.URL.Path, "/v1/responses/") {
+		setCORSHeaders(w)
+		ResponsesHandler(w, r)
+		return
+	}
+
+	if r.URL.Path == "/v1/completions" {
+		setCORSHeaders(w)
+		CompletionsHandler(w, r)
+		return
+	}
+
 	// 检查是否应该使用备用处理器
 	if os.Getenv("USE_FALLBACK") == "true" || os.Getenv("FALLBACK_MODE") == "true" {
 		FallbackHandler(w, r)
@@ -133,364 +200,320 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+	setCORSHeaders(w)
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		log.Printf("Missing or invalid authorization header from IP: %s", r.RemoteAddr)
-		http.Error(w, "Missing or invalid authorization header", http.StatusUnauthorized)
+	if r.Method != "POST" {
+		writeAPIError(w, nil, errMethodNotAllowed(r.Method))
 		return
 	}
-	dsToken := strings.TrimPrefix(authHeader, "Bearer ")
-	log.Printf("Request authenticated, token length: %d", len(dsToken))
-	_ = dsToken // Token for future use
 
-	var openAIReq OpenAIRequest
-	if err := json.NewDecoder(r.Body).Decode(&openAIReq); err != nil {
-		log.Printf("Failed to decode request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	apiKey := authenticate(w, r, nil)
+	if apiKey == nil {
 		return
 	}
 
-	if len(openAIReq.Messages) == 0 {
-		log.Printf("Empty messages array received")
-		http.Error(w, "Messages array cannot be empty", http.StatusBadRequest)
+	openAIReq, apiErr := decodeChatRequest(r)
+	if apiErr != nil {
+		writeAPIError(w, nil, apiErr)
 		return
 	}
 
-	log.Printf("Processing request: model=%s, messages=%d, stream=%v",
-		openAIReq.Model, len(openAIReq.Messages), openAIReq.Stream)
-
-	originalModel = openAIReq.Model
-	lastMessage := openAIReq.Messages[len(openAIReq.Messages)-1].Content
-	var chatHistory []map[string]interface{}
-	for _, msg := range openAIReq.Messages {
-		chatMsg := map[string]interface{}{
-			"question": msg.Content,
-			"answer":   "",
-		}
-		if msg.Role == "assistant" {
-			chatMsg["question"] = ""
-			chatMsg["answer"] = msg.Content
-		}
-		chatHistory = append(chatHistory, chatMsg)
+	youModel, apiErr := mapModelName(openAIReq.Model)
+	if apiErr != nil {
+		writeAPIError(w, nil, apiErr)
+		return
 	}
 
-	chatHistoryJSON, _ := json.Marshal(chatHistory)
-
-	// Construct the original URL
-	originalURL := "https://you.com/api/streamingSearch"
-	q := url.Values{}
-	q.Add("q", lastMessage)
-	q.Add("page", "1")
-	q.Add("count", "10")
-	q.Add("safeSearch", "Moderate")
-	q.Add("mkt", "zh-HK")
-	q.Add("enable_worklow_generation_ux", "true")
-	q.Add("domain", "youchat")
-	q.Add("use_personalization_extraction", "true")
-	q.Add("pastChatLength", fmt.Sprintf("%d", len(chatHistory)-1))
-	q.Add("selectedChatMode", "custom")
-	q.Add("selectedAiModel", mapModelName(openAIReq.Model))
-	q.Add("enable_agent_clarification_questions", "true")
-	q.Add("use_nested_youchat_updates", "true")
-	q.Add("chat", string(chatHistoryJSON))
-
-	// Use a proxy to bypass Cloudflare
-	proxyURL := "https://proxy.cors.sh/" + originalURL + "?" + q.Encode()
-
-	youReq, err := http.NewRequest("GET", proxyURL, nil)
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+	chatReq, apiErr := openAIReq.chatRequest(youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, nil, apiErr)
 		return
 	}
 
-	youReq.Header = http.Header{
-		"x-cors-api-key":     {"live_a48b9b66e68b4b0bb41a3df6de21e59b4a28cfc55b1343a0b0b0f5b5c2e8e8c7"},
-		"User-Agent":         {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
-		"Accept":             {"text/event-stream"},
-		"Accept-Language":    {"en-US,en;q=0.9"},
-		"Accept-Encoding":    {"gzip, deflate, br"},
-		"Referer":            {"https://you.com/"},
-		"Origin":             {"https://you.com"},
-		"DNT":                {"1"},
-		"Connection":         {"keep-alive"},
-		"Sec-Fetch-Dest":     {"empty"},
-		"Sec-Fetch-Mode":     {"cors"},
-		"Sec-Fetch-Site":     {"same-origin"},
-		"sec-ch-ua":          {"\"Not_A Brand\";v=\"8\", \"Chromium\";v=\"120\", \"Google Chrome\";v=\"120\""},
-		"sec-ch-ua-mobile":   {"?0"},
-		"sec-ch-ua-platform": {"\"Windows\""},
+	rc, apiErr := newChatContext(w, r, &openAIReq, youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, nil, apiErr)
+		return
 	}
+	log.Printf("[%s] Processing request: key=%s, model=%s, messages=%d, stream=%v, user=%q",
+		rc.RequestID, apiKey.Name, openAIReq.Model, len(openAIReq.Messages), openAIReq.Stream, openAIReq.User)
+	warnIgnoredParams(w, rc, &openAIReq)
 
-	client := &http.Client{
-		Timeout: 300 * time.Second,
-	}
+	serveChat(w, r, rc, chatReq)
+}
 
-	resp, err := client.Do(youReq)
-	if err != nil {
-		log.Printf("Request error: %v", err)
-		http.Error(w, "Failed to send request to You.com API", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
+// newChatContext 按 chat completions 请求的参数（思考过程、来源、工具、n、JSON 输出、用量和长度限制）
+// 创建请求上下文，Handler 和 FallbackHandler 共用
+func newChatContext(w http.ResponseWriter, r *http.Request, openAIReq *OpenAIRequest, youModel string, apiKey *APIKey) (*requestContext, *APIError) {
+	reasoningMode, apiErr := openAIReq.reasoningMode()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	citationMode, apiErr := openAIReq.citationMode()
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	rc := newRequestContext(w, r, openAIReq.Model, openAIReq.Stream)
+	rc.Key = apiKey
+	rc.ReasoningMode = reasoningMode
+	rc.CitationMode = citationMode
+	rc.YouOptions = openAIReq.YouOptions
+	rc.Tools = openAIReq.toolSet()
+	if openAIReq.N != nil {
+		rc.N = *openAIReq.N
+	}
+	rc.enableJSON(openAIReq.jsonOutput)
+	rc.Tokenizer = tokenizerFor(youModel)
+	rc.PromptTokens = countPromptTokens(rc.Tokenizer, openAIReq.Messages)
+	rc.IncludeUsage = openAIReq.StreamOptions != nil && openAIReq.StreamOptions.IncludeUsage
+	rc.Limits = newOutputLimiter(openAIReq.Stop, openAIReq.completionLimit(), rc.Tokenizer)
+	return rc, nil
+}
 
-	log.Printf("You.com response status: %d", resp.StatusCode)
+// serveChat 调用上游并按 rc.Format 写出响应，主上游失败或没有内容时依次尝试备用上游。
+// 各前端（OpenAI、Anthropic 等）在完成解析和认证后共用这一处理链
+func serveChat(w http.ResponseWriter, r *http.Request, rc *requestContext, chatReq ChatRequest) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	// Read a small part of the response body for debugging
-	bodyPreview := make([]byte, 200)
-	n, _ := resp.Body.Read(bodyPreview)
-	log.Printf("Response body preview (first %d bytes): %s", n, string(bodyPreview[:n]))
+	if apiErr := prepareChat(r, rc, &chatReq); apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+	if rc.N > 1 {
+		serveChoices(w, r, rc, chatReq)
+		return
+	}
 
-	// Reset body reader
-	resp.Body.Close()
-	resp, err = client.Do(youReq)
+	events, err := primaryUpstream.Stream(ctx, chatReq)
 	if err != nil {
-		log.Printf("Failed to re-request: %v", err)
-		http.Error(w, "Failed to re-request You.com API", http.StatusInternalServerError)
+		log.Printf("[%s] Primary upstream %s failed: %v, trying fallback methods...", rc.RequestID, primaryUpstream.Name(), err)
+		respondWithFallback(w, r.Context(), rc, chatReq, err)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		log.Printf("You.com API returned non-200 status: %d, trying fallback methods...", resp.StatusCode)
-		// Try fallback method instead of failing immediately
-		fallbackContent := tryMultipleMethods(lastMessage, originalModel)
-		if fallbackContent == "" {
-			fallbackContent = generateFallbackResponse(lastMessage)
-			log.Printf("Using generated fallback response")
-		} else {
-			log.Printf("Successfully got content from fallback method, length: %d", len(fallbackContent))
-		}
-
-		if openAIReq.Stream {
-			sendStreamResponse(w, fallbackContent, originalModel)
-		} else {
-			sendNormalResponse(w, fallbackContent, originalModel)
-		}
+	events, apiErr := processEvents(ctx, cancel, rc, chatReq, events)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
 		return
 	}
 
-	if openAIReq.Stream {
-		content := handleStreamResponse(w, resp, originalModel)
-		// If primary method returns empty content, try fallback
-		if content == "" {
-			log.Printf("Primary stream method returned empty content, trying fallback...")
-			fallbackContent := tryMultipleMethods(lastMessage, originalModel)
-			if fallbackContent == "" {
-				fallbackContent = generateFallbackResponse(lastMessage)
-			}
-			sendStreamResponse(w, fallbackContent, originalModel)
-		}
+	var content string
+	if rc.Stream {
+		content, err = handleStreamResponse(w, rc, events)
 	} else {
-		content := handleNonStreamResponse(w, resp, originalModel)
-		// If primary method returns empty content, try fallback
-		if content == "" {
-			log.Printf("Primary non-stream method returned empty content, trying fallback...")
-			fallbackContent := tryMultipleMethods(lastMessage, originalModel)
-			if fallbackContent == "" {
-				fallbackContent = generateFallbackResponse(lastMessage)
-			}
-			sendNormalResponse(w, fallbackContent, originalModel)
-		}
+		content, err = handleNonStreamResponse(w, rc, events)
+	}
+	// If primary method returns empty content, try fallback
+	if !rc.hasOutput(content) {
+		log.Printf("[%s] Primary method returned empty content, trying fallback...", rc.RequestID)
+		respondWithFallback(w, r.Context(), rc, chatReq, err)
 	}
 }
 
-func handleStreamResponse(w http.ResponseWriter, resp *http.Response, model string) string {
-	var totalContent strings.Builder
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	scanner := bufio.NewScanner(resp.Body)
-	responseID := "chatcmpl-" + strconv.FormatInt(time.Now().Unix(), 10)
-	created := time.Now().Unix()
-	isDebug := os.Getenv("DEBUG") == "true"
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if isDebug {
-			log.Printf("Raw line: %s", line)
+// prepareChat 合并请求头和 you_options 中的 you.com 设置，并确定思考过程最终的输出方式
+func prepareChat(r *http.Request, rc *requestContext, chatReq *ChatRequest) *APIError {
+	settings, apiErr := defaultYouSettings.Resolve(r.Header, rc.YouOptions)
+	if apiErr != nil {
+		return apiErr
+	}
+	chatReq.Options = &settings
+	rc.ReasoningMode = effectiveReasoningMode(rc)
+	return nil
+}
+
+// processEvents 依次分离思考过程、解析工具调用、校验 JSON 输出并模拟 stop/max_tokens，
+// 截断时调用 cancel 停止读取上游
+func processEvents(ctx context.Context, cancel context.CancelFunc, rc *requestContext, chatReq ChatRequest, events <-chan Event) (<-chan Event, *APIError) {
+	events = splitReasoning(events, rc.ReasoningMode)
+	if rc.Tools != nil {
+		events = extractToolCalls(events, rc.Tools)
+	}
+	if rc.JSON != nil {
+		var apiErr *APIError
+		if events, apiErr = conformJSONEvents(ctx, rc, chatReq, events); apiErr != nil {
+			return nil, apiErr
 		}
+	}
+	return limitEvents(events, rc.Limits, cancel), nil
+}
 
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if isDebug {
-				log.Printf("Data content: %s", data)
-			}
+// setCORSHeaders 允许浏览器跨域调用
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+}
 
-			if data == "[DONE]" {
-				// Send final chunk
-				finalChunk := OpenAIStreamResponse{
-					ID:      responseID,
-					Object:  "chat.completion.chunk",
-					Created: created,
-					Model:   model,
-					Choices: []Choice{{
-						Delta:        Delta{Content: ""},
-						Index:        0,
-						FinishReason: "stop",
-					}},
-				}
-				if jsonData, err := json.Marshal(finalChunk); err == nil {
-					fmt.Fprintf(w, "data: %s\n\n", string(jsonData))
-				}
-				fmt.Fprint(w, "data: [DONE]\n\n")
-				break
-			} else if data != "" && data != "{}" {
-				var youResp map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &youResp); err == nil {
-					if isDebug {
-						log.Printf("Parsed response: %+v", youResp)
-					}
+// decodeChatRequest 解析并校验 Chat Completions 请求体
+func decodeChatRequest(r *http.Request) (OpenAIRequest, *APIError) {
+	var openAIReq OpenAIRequest
+	if err := json.NewDecoder(r.Body).Decode(&openAIReq); err != nil {
+		log.Printf("Failed to decode request body: %v", err)
+		return openAIReq, errInvalidRequest("Invalid request body: " + err.Error())
+	}
 
-					// Try multiple possible field names for the content
-					var content string
-					if youChatToken, exists := youResp["youChatToken"].(string); exists && youChatToken != "" {
-						content = youChatToken
-					} else if text, exists := youResp["text"].(string); exists && text != "" {
-						content = text
-					} else if message, exists := youResp["message"].(string); exists && message != "" {
-						content = message
-					} else if delta, exists := youResp["delta"].(map[string]interface{}); exists {
-						if deltaContent, exists := delta["content"].(string); exists && deltaContent != "" {
-							content = deltaContent
-						}
-					}
+	if len(openAIReq.Messages) == 0 {
+		log.Printf("Empty messages array received")
+		return openAIReq, errInvalidRequest("Messages array cannot be empty").withParam("messages")
+	}
+	if apiErr := openAIReq.validate(); apiErr != nil {
+		return openAIReq, apiErr
+	}
+	return openAIReq, nil
+}
 
-					if content != "" {
-						totalContent.WriteString(content)
-						chunk := OpenAIStreamResponse{
-							ID:      responseID,
-							Object:  "chat.completion.chunk",
-							Created: created,
-							Model:   model,
-							Choices: []Choice{{
-								Delta: Delta{Content: content},
-								Index: 0,
-							}},
-						}
-						if jsonData, err := json.Marshal(chunk); err == nil {
-							fmt.Fprintf(w, "data: %s\n\n", string(jsonData))
-						}
-					} else if isDebug {
-						log.Printf("No content found in response: %+v", youResp)
-					}
-				} else if isDebug {
-					log.Printf("Failed to parse JSON: %v, data: %s", err, data)
-				}
-			}
-		}
-		if flusher, ok := w.(http.Flusher); ok {
-			flusher.Flush()
+// respondWithFallback 依次尝试备用上游，全部失败时返回上游错误
+func respondWithFallback(w http.ResponseWriter, ctx context.Context, rc *requestContext, req ChatRequest, cause error) {
+	fallbackContent, err := tryMultipleMethods(ctx, req)
+	if fallbackContent == "" {
+		if err == nil {
+			err = cause
 		}
+		writeAPIError(w, rc, upstreamFailure(err))
+		return
 	}
+	log.Printf("[%s] Successfully got content from fallback method, length: %d", rc.RequestID, len(fallbackContent))
 
-	result := totalContent.String()
-	log.Printf("handleStreamResponse returning content length: %d", len(result))
-	return result
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, apiErr := processEvents(ctx, cancel, rc, req, textEvents(fallbackContent))
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+	var content string
+	if rc.Stream {
+		content, err = handleStreamResponse(w, rc, events)
+	} else {
+		content, err = handleNonStreamResponse(w, rc, events)
+	}
+	if !rc.hasOutput(content) {
+		writeAPIError(w, rc, upstreamFailure(err))
+	}
 }
 
-func handleNonStreamResponse(w http.ResponseWriter, resp *http.Response, model string) string {
-	w.Header().Set("Content-Type", "application/json")
-
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	isDebug := os.Getenv("DEBUG") == "true"
-	lineCount := 0
+// textEvents 返回只包含一段完整文本的事件流，备用上游的回答由此和主上游一样经过 processEvents 和响应写出
+func textEvents(content string) <-chan Event {
+	events := make(chan Event, 1)
+	events <- Event{Text: content}
+	close(events)
+	return events
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
+// handleStreamResponse 将上游事件转换为 OpenAI 流式响应。
+// 上游没有返回任何内容时不写入响应，返回空字符串，由调用方回退；
+// 已经输出内容后上游出错，则以 SSE error 事件结束流。
+func handleStreamResponse(w http.ResponseWriter, rc *requestContext, events <-chan Event) (string, error) {
+	var totalContent strings.Builder
+	var streamErr error
 
-		if isDebug {
-			log.Printf("Non-stream line %d: %s", lineCount, line)
+	for ev := range events {
+		if ev.Err != nil {
+			log.Printf("[%s] Upstream stream error: %v", rc.RequestID, ev.Err)
+			streamErr = ev.Err
+			continue
 		}
+		totalContent.WriteString(writeStreamEvent(w, rc, ev))
+	}
 
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if isDebug {
-				log.Printf("Non-stream data: %s", data)
-			}
+	result := totalContent.String()
+	log.Printf("[%s] handleStreamResponse returning content length: %d", rc.RequestID, len(result))
+	if !rc.hasOutput(result) {
+		return "", streamErr
+	}
+	rc.startStream(w)
 
-			if data != "[DONE]" && data != "" && data != "{}" {
-				var youResp map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &youResp); err == nil {
-					if isDebug {
-						log.Printf("Non-stream parsed: %+v", youResp)
-					}
+	if streamErr != nil {
+		writeAPIError(w, rc, upstreamFailure(streamErr))
+		return result, streamErr
+	}
 
-					// Log all available fields for debugging
-					log.Printf("Available fields in response: %v", getMapKeys(youResp))
-
-					// Try multiple possible field names for the content
-					var content string
-					if youChatToken, exists := youResp["youChatToken"].(string); exists && youChatToken != "" {
-						content = youChatToken
-					} else if text, exists := youResp["text"].(string); exists && text != "" {
-						content = text
-					} else if message, exists := youResp["message"].(string); exists && message != "" {
-						content = message
-					} else if delta, exists := youResp["delta"].(map[string]interface{}); exists {
-						if deltaContent, exists := delta["content"].(string); exists && deltaContent != "" {
-							content = deltaContent
-						}
-					}
+	result = writeFootnotes(w, rc, result)
+	finishStream(w, rc, result)
+	return result, nil
+}
 
-					if content != "" {
-						fullResponse.WriteString(content)
-					} else if isDebug {
-						log.Printf("No content found in non-stream response: %+v", youResp)
-					}
-				} else if isDebug {
-					log.Printf("Failed to parse non-stream JSON: %v, data: %s", err, data)
-				}
-			}
+// writeStreamEvent 写出一个上游事件中的工具调用、思考过程和回答，返回其中的回答文本
+func writeStreamEvent(w http.ResponseWriter, rc *requestContext, ev Event) string {
+	rc.addCitations(ev.Citations)
+	if len(ev.ToolCalls) > 0 {
+		if tw, ok := rc.Format.(toolCallWriter); ok {
+			rc.startStream(w)
+			tw.writeToolCallDeltas(w, rc, ev.ToolCalls)
+			rc.ToolCalls = append(rc.ToolCalls, ev.ToolCalls...)
 		}
 	}
+	if ev.Reasoning != "" {
+		if rw, ok := rc.Format.(reasoningWriter); ok {
+			rc.startStream(w)
+			rc.Reasoning.WriteString(ev.Reasoning)
+			rw.writeReasoningDelta(w, rc, ev.Reasoning)
+		}
+	}
+	if ev.Text == "" {
+		return ""
+	}
+	rc.startStream(w)
+	rc.Format.writeDelta(w, rc, ev.Text)
+	return ev.Text
+}
 
-	finalContent := fullResponse.String()
-	if isDebug {
-		log.Printf("Final response content length: %d, content: %s", len(finalContent), finalContent)
+// writeFootnotes 在 markdown 模式下发送来源列表，返回追加后的完整输出
+func writeFootnotes(w http.ResponseWriter, rc *requestContext, content string) string {
+	footnotes := rc.citationFootnotes()
+	if footnotes == "" {
+		return content
 	}
+	rc.Format.writeDelta(w, rc, footnotes)
+	return content + footnotes
+}
 
-	// If no content was found, provide a fallback message
-	if finalContent == "" {
-		finalContent = "I apologize, but I couldn't process your request at this time. Please try again."
-		if isDebug {
-			log.Printf("Using fallback content")
+// finishStream 按响应格式结束流式响应
+func finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	rc.Format.finishStream(w, rc, content)
+}
+
+// handleNonStreamResponse 读取全部上游事件并返回 OpenAI 响应。
+// 上游没有返回任何内容时不写入响应，返回空字符串，由调用方回退。
+func handleNonStreamResponse(w http.ResponseWriter, rc *requestContext, events <-chan Event) (string, error) {
+	var content strings.Builder
+	var err error
+	for ev := range events {
+		if ev.Err != nil {
+			err = ev.Err
+			continue
 		}
+		content.WriteString(collectEvent(rc, ev))
 	}
-
-	openAIResp := OpenAIResponse{
-		ID:      "chatcmpl-" + strconv.FormatInt(time.Now().Unix(), 10),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: []OpenAIChoice{{
-			Message: Message{
-				Role:    "assistant",
-				Content: finalContent,
-			},
-			Index:        0,
-			FinishReason: "stop",
-		}},
+	finalContent := content.String()
+	if err != nil {
+		log.Printf("[%s] Upstream stream error: %v", rc.RequestID, err)
+	}
+	if os.Getenv("DEBUG") == "true" {
+		log.Printf("Final response content length: %d, content: %s", len(finalContent), finalContent)
 	}
 
-	if err := json.NewEncoder(w).Encode(openAIResp); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return ""
+	if rc.hasOutput(finalContent) {
+		finalContent += rc.citationFootnotes()
+		rc.Format.writeResponse(w, rc, finalContent)
 	}
 
-	log.Printf("handleNonStreamResponse returning content length: %d", len(finalContent))
-	return finalContent
+	log.Printf("[%s] handleNonStreamResponse returning content length: %d", rc.RequestID, len(finalContent))
+	return finalContent, err
+}
+
+// collectEvent 记录非流式响应中一个上游事件的思考过程、来源和工具调用，返回其中的回答文本
+func collectEvent(rc *requestContext, ev Event) string {
+	rc.Reasoning.WriteString(ev.Reasoning)
+	rc.addCitations(ev.Citations)
+	rc.ToolCalls = append(rc.ToolCalls, ev.ToolCalls...)
+	return ev.Text
 }
 
 // TestHandler - 简化的测试处理程序，用于调试You.com API
@@ -631,7 +654,7 @@ func testWithCORSProxy(message string) map[string]interface{} {
 	log.Printf("CORS Proxy response status: %d", resp.StatusCode)
 
 	// 解析流式响应
-	content := parseTestStreamResponse(resp.Body)
+	content := parseTestStreamResponse(resp)
 
 	return map[string]interface{}{
 		"status_code":    resp.StatusCode,
@@ -642,48 +665,43 @@ func testWithCORSProxy(message string) map[string]interface{} {
 
 func parseTestStreamResponse(resp *http.Response) string {
 	var content strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	lineCount := 0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
-
-		log.Printf("Stream line %d: %s", lineCount, truncateString(line, 200))
-
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
+	reader := sse.NewReader(resp.Body, sseMaxSize)
+	eventCount := 0
+
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, sse.ErrTooLarge) {
+			log.Printf("Skipping stream event larger than %d bytes", sseMaxSize)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Stream read error: %v", err)
 			}
+			break
+		}
+		eventCount++
 
-			if data != "" && data != "{}" {
-				var parsed map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &parsed); err == nil {
-					log.Printf("Parsed JSON: %+v", parsed)
-
-					// 尝试多种字段名
-					fields := []string{"youChatToken", "text", "message", "content", "answer"}
-					for _, field := range fields {
-						if value, exists := parsed[field].(string); exists && value != "" {
-							content.WriteString(value)
-							log.Printf("Found content in field '%s': %s", field, truncateString(value, 100))
-							break
-						}
-					}
+		log.Printf("Stream event %d (%s): %s", eventCount, record.Event, truncateString(record.Data, 200))
 
-					// 检查嵌套对象
-					if delta, exists := parsed["delta"].(map[string]interface{}); exists {
-						if deltaContent, exists := delta["content"].(string); exists && deltaContent != "" {
-							content.WriteString(deltaContent)
-							log.Printf("Found content in delta.content: %s", truncateString(deltaContent, 100))
-						}
-					}
-				} else {
-					log.Printf("Failed to parse JSON: %v", err)
-					log.Printf("Raw data: %s", truncateString(data, 200))
-				}
-			}
+		data := record.Data
+		if data == "[DONE]" {
+			break
+		}
+
+		if data == "" || data == "{}" {
+			continue
+		}
+		decoded, err := decodeYouEvent(record.Event, data)
+		if err != nil {
+			log.Printf("Failed to decode %s event: %v", record.Event, err)
+			continue
+		}
+		if token, ok := decoded.(*youTokenEvent); ok && token.YouChatToken != "" {
+			content.WriteString(token.YouChatToken)
+		}
+		if _, ok := decoded.(*youDoneEvent); ok {
+			break
 		}
 	}
 
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// modelsCreated 是 /v1/models 中统一使用的创建时间，you.com 不提供模型发布日期
+const modelsCreated int64 = 1704067200 // 2024-01-01T00:00:00Z
+
+// ModelObject 是 /v1/models 返回的单个模型，context_window 等为扩展字段
+type ModelObject struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Created       int64  `json:"created"`
+	OwnedBy       string `json:"owned_by"`
+	YouModel      string `json:"you_model,omitempty"`
+	ContextWindow int    `json:"context_window,omitempty"`
+	Reasoning     bool   `json:"reasoning"`
+}
+
+// ModelList 是 /v1/models 的响应
+type ModelList struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+// modelMeta 是模型的附加信息
+type modelMeta struct {
+	ContextWindow int
+	Reasoning     bool // 是否为推理模型（会输出思考过程）
+}
+
+var modelMetadata = map[string]modelMeta{
+	"deepseek-reasoner":  {ContextWindow: 65536, Reasoning: true},
+	"deepseek-chat":      {ContextWindow: 65536},
+	"o3-mini-high":       {ContextWindow: 200000, Reasoning: true},
+	"o3-mini-medium":     {ContextWindow: 200000, Reasoning: true},
+	"o1":                 {ContextWindow: 200000, Reasoning: true},
+	"o1-mini":            {ContextWindow: 128000, Reasoning: true},
+	"o1-preview":         {ContextWindow: 128000, Reasoning: true},
+	"gpt-4o":             {ContextWindow: 128000},
+	"gpt-4o-mini":        {ContextWindow: 128000},
+	"gpt-4-turbo":        {ContextWindow: 128000},
+	"gpt-3.5-turbo":      {ContextWindow: 16385},
+	"claude-3-opus":      {ContextWindow: 200000},
+	"claude-3-sonnet":    {ContextWindow: 200000},
+	"claude-3.5-sonnet":  {ContextWindow: 200000},
+	"claude-3.5-haiku":   {ContextWindow: 200000},
+	"gemini-1.5-pro":     {ContextWindow: 2097152},
+	"gemini-1.5-flash":   {ContextWindow: 1048576},
+	"llama-3.2-90b":      {ContextWindow: 131072},
+	"llama-3.1-405b":     {ContextWindow: 131072},
+	"mistral-large-2":    {ContextWindow: 131072},
+	"qwen-2.5-72b":       {ContextWindow: 131072},
+	"qwen-2.5-coder-32b": {ContextWindow: 131072},
+	"command-r-plus":     {ContextWindow: 128000},
+}
+
+// modelOwners 按模型名前缀推断 owned_by
+var modelOwners = []struct {
+	prefix string
+	owner  string
+}{
+	{"deepseek", "deepseek"},
+	{"gpt", "openai"},
+	{"o1", "openai"},
+	{"o3", "openai"},
+	{"claude", "anthropic"},
+	{"gemini", "google"},
+	{"llama", "meta"},
+	{"mistral", "mistralai"},
+	{"qwen", "alibaba"},
+	{"command", "cohere"},
+}
+
+// modelOwner 返回模型所属厂商，无法识别时为 you.com
+func modelOwner(id string) string {
+	for _, o := range modelOwners {
+		if strings.HasPrefix(id, o.prefix) {
+			return o.owner
+		}
+	}
+	return "you.com"
+}
+
+// newModelObject 根据 modelMap 中的条目构造模型对象
+func newModelObject(id string) ModelObject {
+	meta := modelMetadata[id]
+	return ModelObject{
+		ID:            id,
+		Object:        "model",
+		Created:       modelsCreated,
+		OwnedBy:       modelOwner(id),
+		YouModel:      modelMap[id],
+		ContextWindow: meta.ContextWindow,
+		Reasoning:     meta.Reasoning,
+	}
+}
+
+// listModels 返回按名称排序的全部模型
+func listModels() []ModelObject {
+	ids := make([]string, 0, len(modelMap))
+	for id := range modelMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	models := make([]ModelObject, 0, len(ids))
+	for _, id := range ids {
+		models = append(models, newModelObject(id))
+	}
+	return models
+}
+
+// ModelsHandler 处理 GET /v1/models 和 GET /v1/models/{id}
+func ModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeAPIError(w, nil, errMethodNotAllowed(r.Method))
+		return
+	}
+
+	if authenticate(w, r, nil) == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/")
+	if id == "" {
+		json.NewEncoder(w).Encode(ModelList{Object: "list", Data: listModels()})
+		return
+	}
+
+	if _, exists := modelMap[id]; !exists {
+		writeAPIError(w, nil, errModelNotFound(id))
+		return
+	}
+	json.NewEncoder(w).Encode(newModelObject(id))
+}
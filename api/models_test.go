@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// getModels 以已认证的请求调用 ModelsHandler
+func getModels(method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	Handler(rec, req)
+	return rec
+}
+
+func TestModelsList(t *testing.T) {
+	rec := getModels("GET", "/v1/models")
+
+	var list ModelList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("invalid response %d %s: %v", rec.Code, rec.Body, err)
+	}
+	if list.Object != "list" || len(list.Data) != len(modelMap) {
+		t.Fatalf("got %d models, want %d", len(list.Data), len(modelMap))
+	}
+	if !sort.SliceIsSorted(list.Data, func(i, j int) bool { return list.Data[i].ID < list.Data[j].ID }) {
+		t.Error("models are not sorted by id")
+	}
+	for _, m := range list.Data {
+		if m.Object != "model" || m.YouModel != modelMap[m.ID] || m.Created != modelsCreated {
+			t.Errorf("model %+v", m)
+		}
+		if m.ContextWindow == 0 {
+			t.Errorf("model %s has no context_window metadata", m.ID)
+		}
+	}
+}
+
+func TestModelsGet(t *testing.T) {
+	rec := getModels("GET", "/v1/models/deepseek-reasoner")
+	var m ModelObject
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("invalid response %d %s: %v", rec.Code, rec.Body, err)
+	}
+	want := ModelObject{ID: "deepseek-reasoner", Object: "model", Created: modelsCreated, OwnedBy: "deepseek", YouModel: "deepseek_r1", ContextWindow: 65536, Reasoning: true}
+	if m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+
+	rec = getModels("GET", "/v1/models/gpt-4o")
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil || m.Reasoning || m.OwnedBy != "openai" {
+		t.Errorf("gpt-4o = %+v, %v", m, err)
+	}
+
+	rec = getModels("GET", "/v1/models/no-such-model")
+	var body struct{ Error APIError }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusNotFound || body.Error.Code == nil || *body.Error.Code != "model_not_found" {
+		t.Errorf("unknown model: got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec = getModels("POST", "/v1/models"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /v1/models: status = %d", rec.Code)
+	}
+}
+
+func TestModelOwner(t *testing.T) {
+	tests := map[string]string{
+		"deepseek-chat":     "deepseek",
+		"gpt-3.5-turbo":     "openai",
+		"o1-mini":           "openai",
+		"o3-mini-high":      "openai",
+		"claude-3.5-sonnet": "anthropic",
+		"gemini-1.5-pro":    "google",
+		"llama-3.1-405b":    "meta",
+		"mistral-large-2":   "mistralai",
+		"qwen-2.5-72b":      "alibaba",
+		"command-r-plus":    "cohere",
+		"mystery-model":     "you.com",
+	}
+	for id, want := range tests {
+		if got := modelOwner(id); got != want {
+			t.Errorf("modelOwner(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestModelMetadataCoversModelMap(t *testing.T) {
+	for id := range modelMap {
+		if _, ok := modelMetadata[id]; !ok {
+			t.Errorf("modelMetadata has no entry for %s", id)
+		}
+	}
+	for id := range modelMetadata {
+		if _, ok := modelMap[id]; !ok {
+			t.Errorf("modelMetadata entry %s is not in modelMap", id)
+		}
+	}
+}
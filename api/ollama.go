@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaVersion 是 /api/version 返回的版本号，部分客户端据此判断接口能力
+const ollamaVersion = "0.5.7"
+
+// OllamaOptions 是 Ollama 请求 options 中代理能够识别的字段，其余字段忽略
+type OllamaOptions struct {
+	Temperature *float64      `json:"temperature,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	TopK        *int          `json:"top_k,omitempty"`
+	NumPredict  *int          `json:"num_predict,omitempty"` // 小于等于 0 表示不限制
+	Stop        StopSequences `json:"stop,omitempty"`
+	Seed        *int64        `json:"seed,omitempty"`
+}
+
+// OllamaMessage 是 /api/chat 中的消息，images 为 base64 图片
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// OllamaChatRequest 对应 POST /api/chat
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   *bool           `json:"stream,omitempty"` // Ollama 默认流式
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  OllamaOptions   `json:"options"`
+}
+
+// OllamaGenerateRequest 对应 POST /api/generate
+type OllamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	System  string          `json:"system,omitempty"`
+	Images  []string        `json:"images,omitempty"`
+	Stream  *bool           `json:"stream,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options OllamaOptions   `json:"options"`
+	Context []int           `json:"context,omitempty"`
+}
+
+// OllamaResponse 是 /api/chat 和 /api/generate 的响应行，流式响应中每行一个 JSON 对象（NDJSON）
+type OllamaResponse struct {
+	Model              string         `json:"model"`
+	CreatedAt          string         `json:"created_at"`
+	Message            *OllamaMessage `json:"message,omitempty"`  // /api/chat
+	Response           *string        `json:"response,omitempty"` // /api/generate
+	Done               bool           `json:"done"`
+	DoneReason         string         `json:"done_reason,omitempty"`
+	TotalDuration      int64          `json:"total_duration,omitempty"`
+	LoadDuration       int64          `json:"load_duration,omitempty"`
+	PromptEvalCount    int            `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64          `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int            `json:"eval_count,omitempty"`
+	EvalDuration       int64          `json:"eval_duration,omitempty"`
+}
+
+// OllamaModel 是 /api/tags 中的单个模型
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaModelDetails 是模型的详细信息，you.com 模型没有本地文件，只填写家族
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaHandler 处理 Ollama 兼容端点 /api/chat、/api/generate、/api/tags 和 /api/version，
+// 请求转换为 OpenAIRequest 后复用 Chat Completions 的处理链，流式响应为 NDJSON
+func OllamaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.URL.Path == "/api/version" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"version": ollamaVersion})
+		return
+	}
+
+	format := &ollamaFormat{generate: r.URL.Path == "/api/generate", start: time.Now()}
+	rc := newRequestContext(w, r, "", false)
+	rc.Format = format
+
+	wantMethod := "POST"
+	if r.URL.Path == "/api/tags" {
+		wantMethod = "GET"
+	}
+	if r.Method != wantMethod {
+		writeAPIError(w, rc, errMethodNotAllowed(r.Method))
+		return
+	}
+
+	// Ollama 客户端大多不能设置请求头，未配置密钥（开放模式）时不要求认证
+	apiKey := &APIKey{Name: "anonymous"}
+	if !keyStore.open() {
+		if apiKey = authenticate(w, r, rc); apiKey == nil {
+			return
+		}
+	}
+
+	var (
+		openAIReq OpenAIRequest
+		extra     []string
+		apiErr    *APIError
+	)
+	switch r.URL.Path {
+	case "/api/tags":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]OllamaModel{"models": listOllamaModels()})
+		return
+	case "/api/chat":
+		var chatReq OllamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&chatReq); err != nil {
+			writeAPIError(w, rc, errInvalidRequest("Invalid request body: "+err.Error()))
+			return
+		}
+		openAIReq, extra, apiErr = chatReq.toOpenAI()
+	case "/api/generate":
+		var genReq OllamaGenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&genReq); err != nil {
+			writeAPIError(w, rc, errInvalidRequest("Invalid request body: "+err.Error()))
+			return
+		}
+		openAIReq, extra, apiErr = genReq.toOpenAI()
+	default:
+		writeAPIError(w, rc, newAPIError(http.StatusNotFound, "invalid_request_error", "not_found", "unknown endpoint "+r.URL.Path))
+		return
+	}
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	// 响应中写回客户端请求的模型名（含 :latest 标签），解析时去掉标签
+	rc.Model = openAIReq.Model
+	openAIReq.Model = ollamaModelName(openAIReq.Model)
+	youModel, apiErr := mapModelName(openAIReq.Model)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	chatReq, apiErr := openAIReq.chatRequest(youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	rc.Stream = openAIReq.Stream
+	rc.Key = apiKey
+	rc.Tokenizer = tokenizerFor(youModel)
+	rc.PromptTokens = countPromptTokens(rc.Tokenizer, openAIReq.Messages)
+	rc.Limits = newOutputLimiter(openAIReq.Stop, openAIReq.completionLimit(), rc.Tokenizer)
+	rc.enableJSON(openAIReq.jsonOutput)
+	log.Printf("[%s] Processing Ollama request %s: key=%s, model=%s, messages=%d, stream=%v",
+		rc.RequestID, r.URL.Path, apiKey.Name, openAIReq.Model, len(openAIReq.Messages), openAIReq.Stream)
+	warnIgnoredParams(w, rc, &openAIReq, extra...)
+
+	serveChat(w, r, rc, chatReq)
+}
+
+// toOpenAI 将 /api/chat 请求转换为 OpenAIRequest，同时返回被忽略的 Ollama 特有参数
+func (req *OllamaChatRequest) toOpenAI() (OpenAIRequest, []string, *APIError) {
+	openAIReq, extra, apiErr := req.Options.toOpenAI(req.Model, req.Stream, req.Format)
+	if apiErr != nil {
+		return openAIReq, nil, apiErr
+	}
+	for i, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			return openAIReq, nil, errUnsupportedContent("Image inputs are not supported by this proxy: you.com does not accept images through the chat API").withParam(
+				fmt.Sprintf("messages[%d].images", i))
+		}
+		openAIReq.Messages = append(openAIReq.Messages, Message{Role: msg.Role, Content: msg.Content})
+	}
+	if len(openAIReq.Messages) == 0 {
+		return openAIReq, nil, errInvalidRequest("messages cannot be empty").withParam("messages")
+	}
+	apiErr = validateOllamaRequest(&openAIReq)
+	return openAIReq, extra, apiErr
+}
+
+// toOpenAI 将 /api/generate 请求转换为 OpenAIRequest：system 和 prompt 分别作为 system 和 user 消息
+func (req *OllamaGenerateRequest) toOpenAI() (OpenAIRequest, []string, *APIError) {
+	openAIReq, extra, apiErr := req.Options.toOpenAI(req.Model, req.Stream, req.Format)
+	if apiErr != nil {
+		return openAIReq, nil, apiErr
+	}
+	if len(req.Images) > 0 {
+		return openAIReq, nil, errUnsupportedContent("Image inputs are not supported by this proxy: you.com does not accept images through the chat API").withParam("images")
+	}
+	if len(req.Context) > 0 {
+		extra = append(extra, "context")
+	}
+	if req.System != "" {
+		openAIReq.Messages = append(openAIReq.Messages, Message{Role: "system", Content: req.System})
+	}
+	openAIReq.Messages = append(openAIReq.Messages, Message{Role: "user", Content: req.Prompt})
+	apiErr = validateOllamaRequest(&openAIReq)
+	return openAIReq, extra, apiErr
+}
+
+// toOpenAI 转换 options 中的通用参数和 format；Ollama 的 stream 默认为 true
+func (o OllamaOptions) toOpenAI(model string, stream *bool, format json.RawMessage) (OpenAIRequest, []string, *APIError) {
+	req := OpenAIRequest{
+		Model:       model,
+		Stream:      stream == nil || *stream,
+		Temperature: o.Temperature,
+		TopP:        o.TopP,
+		Stop:        o.Stop,
+		Seed:        o.Seed,
+	}
+	if o.NumPredict != nil && *o.NumPredict > 0 {
+		req.MaxTokens = o.NumPredict
+	}
+
+	var apiErr *APIError
+	if req.ResponseFormat, apiErr = ollamaResponseFormat(format); apiErr != nil {
+		return req, nil, apiErr
+	}
+
+	var extra []string
+	if o.TopK != nil {
+		extra = append(extra, "top_k")
+	}
+	return req, extra, nil
+}
+
+// ollamaResponseFormat 将 Ollama 的 format 转换为 response_format："json" 对应 json_object，
+// JSON Schema 对象对应 json_schema。空值表示普通文本
+func ollamaResponseFormat(format json.RawMessage) (*ResponseFormat, *APIError) {
+	trimmed := bytes.TrimSpace(format)
+	if len(trimmed) == 0 || string(trimmed) == "null" || string(trimmed) == `""` {
+		return nil, nil
+	}
+	switch trimmed[0] {
+	case '"':
+		var name string
+		if json.Unmarshal(trimmed, &name) == nil && name == "json" {
+			return &ResponseFormat{Type: "json_object"}, nil
+		}
+	case '{':
+		return &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "response", Schema: trimmed}}, nil
+	}
+	return nil, errInvalidRequest(`format must be "json" or a JSON schema object`).withParam("format")
+}
+
+// validateOllamaRequest 校验转换后的请求，response_format 的错误改为指向 Ollama 的 format 参数
+func validateOllamaRequest(req *OpenAIRequest) *APIError {
+	apiErr := req.validate()
+	if apiErr != nil && apiErr.Param != nil && strings.HasPrefix(*apiErr.Param, "response_format") {
+		apiErr = apiErr.withParam("format")
+	}
+	return apiErr
+}
+
+// ollamaModelName 去掉 Ollama 客户端附加的 :latest 标签
+func ollamaModelName(model string) string {
+	return strings.TrimSuffix(model, ":latest")
+}
+
+// listOllamaModels 将 modelMap 中的模型转换为 /api/tags 的格式
+func listOllamaModels() []OllamaModel {
+	modifiedAt := time.Unix(modelsCreated, 0).UTC().Format(time.RFC3339)
+	var models []OllamaModel
+	for _, m := range listModels() {
+		digest := sha256.Sum256([]byte(m.YouModel))
+		models = append(models, OllamaModel{
+			Name:       m.ID + ":latest",
+			Model:      m.ID + ":latest",
+			ModifiedAt: modifiedAt,
+			Digest:     hex.EncodeToString(digest[:]),
+			Details: OllamaModelDetails{
+				Format:   "api",
+				Family:   m.OwnedBy,
+				Families: []string{m.OwnedBy},
+			},
+		})
+	}
+	return models
+}
+
+// ollamaFormat 是 Ollama 的响应格式，流式响应每行一个 JSON 对象，最后一行 done 为 true 并带统计信息
+type ollamaFormat struct {
+	generate   bool      // /api/generate 使用 response 字段，/api/chat 使用 message 字段
+	start      time.Time // 收到请求的时间
+	firstToken time.Time // 第一段输出的时间
+}
+
+func (f *ollamaFormat) streamContentType() string {
+	return "application/x-ndjson"
+}
+
+// line 构造一行响应
+func (f *ollamaFormat) line(rc *requestContext, text string) OllamaResponse {
+	resp := OllamaResponse{
+		Model:     rc.Model,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if f.generate {
+		resp.Response = &text
+	} else {
+		resp.Message = &OllamaMessage{Role: "assistant", Content: text}
+	}
+	return resp
+}
+
+// final 构造 done 为 true 的最后一行，durations 以纳秒为单位
+func (f *ollamaFormat) final(rc *requestContext, text, content string) OllamaResponse {
+	now := time.Now()
+	if f.firstToken.IsZero() {
+		f.firstToken = now
+	}
+	resp := f.line(rc, text)
+	resp.Done = true
+	resp.DoneReason = rc.Limits.FinishReason()
+	resp.TotalDuration = now.Sub(f.start).Nanoseconds()
+	resp.PromptEvalCount = rc.PromptTokens
+	resp.PromptEvalDuration = f.firstToken.Sub(f.start).Nanoseconds()
+	resp.EvalCount = rc.Tokenizer.Count(content)
+	resp.EvalDuration = now.Sub(f.firstToken).Nanoseconds()
+	return resp
+}
+
+func (f *ollamaFormat) writeDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	if f.firstToken.IsZero() {
+		f.firstToken = time.Now()
+	}
+	json.NewEncoder(w).Encode(f.line(rc, text))
+	flush(w)
+}
+
+func (f *ollamaFormat) finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	json.NewEncoder(w).Encode(f.final(rc, "", content))
+	flush(w)
+}
+
+func (f *ollamaFormat) writeResponse(w http.ResponseWriter, rc *requestContext, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.final(rc, content, content))
+}
+
+// writeError 写出 Ollama 格式的错误 {"error":"..."}，流式响应中作为单独一行发送
+func (f *ollamaFormat) writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	body := map[string]string{"error": apiErr.Message}
+	if rc != nil && rc.streamStarted {
+		json.NewEncoder(w).Encode(body)
+		flush(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(body)
+}
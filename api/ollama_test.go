@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaChatNDJSON(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"Hello", ", world"}})
+
+	req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"gpt-4o:latest","messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+
+	var lines []OllamaResponse
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line OllamaResponse
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+
+	var content strings.Builder
+	for _, line := range lines[:2] {
+		if line.Done || line.Message == nil {
+			t.Fatalf("unexpected intermediate line %+v", line)
+		}
+		content.WriteString(line.Message.Content)
+	}
+	if content.String() != "Hello, world" {
+		t.Errorf("content = %q, want %q", content.String(), "Hello, world")
+	}
+
+	last := lines[2]
+	if !last.Done || last.DoneReason != "stop" || last.EvalCount == 0 || last.PromptEvalCount == 0 {
+		t.Errorf("final line = %+v, want done with counts", last)
+	}
+	if last.Model != "gpt-4o:latest" {
+		t.Errorf("model = %q, want the requested name", last.Model)
+	}
+}
+
+func TestOllamaTagsListsModelMap(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var resp struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	if len(resp.Models) != len(modelMap) {
+		t.Errorf("got %d models, want %d", len(resp.Models), len(modelMap))
+	}
+}
+
+func TestOllamaFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		tokens  []string
+		content string
+	}{
+		{"json", `"json"`, []string{"Sure! ", `{"ok":`, ` true}`}, `{"ok": true}`},
+		{"schema", `{"type":"object","properties":{"age":{"type":"integer"}},"required":["age"]}`, []string{"```json\n", `{"age":36}`, "\n```"}, `{"age":36}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withUpstreams(t, &MockUpstream{Tokens: tt.tokens})
+
+			body := `{"model":"gpt-4o","stream":false,"format":` + tt.format + `,"messages":[{"role":"user","content":"hi"}]}`
+			rec := httptest.NewRecorder()
+			Handler(rec, httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+
+			var resp OllamaResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Message == nil {
+				t.Fatalf("invalid response %d %s: %v", rec.Code, rec.Body, err)
+			}
+			if resp.Message.Content != tt.content {
+				t.Errorf("content = %q, want %q", resp.Message.Content, tt.content)
+			}
+			if w := rec.Header().Get("X-You2Api-Warning"); strings.Contains(w, "format") {
+				t.Errorf("format reported as ignored: %q", w)
+			}
+		})
+	}
+
+	withUpstreams(t, &MockUpstream{Tokens: []string{"unused"}})
+	for _, format := range []string{`"yaml"`, `42`, `{"properties":[]}`} {
+		body := `{"model":"gpt-4o","prompt":"hi","format":` + format + `}`
+		rec := httptest.NewRecorder()
+		Handler(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"error"`) {
+			t.Errorf("format %s: got %d %s, want 400", format, rec.Code, rec.Body)
+		}
+	}
+}
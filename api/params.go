@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// StopSequences 对应 OpenAI 的 stop 参数，可以是字符串或字符串数组
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*s = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		*s = StopSequences{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = list
+	return nil
+}
+
+// StreamOptions 对应 OpenAI 的 stream_options 参数
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// warningHeader 用于告知客户端哪些参数被忽略
+const warningHeader = "X-You2Api-Warning"
+
+// maxStopSequences 与 OpenAI 的限制一致
+const maxStopSequences = 4
+
+// completionLimit 返回生效的 max_tokens，两个参数都设置时取较小值，0 表示不限制
+func (req *OpenAIRequest) completionLimit() int {
+	limit := 0
+	for _, v := range []*int{req.MaxTokens, req.MaxCompletionTokens} {
+		if v != nil && (limit == 0 || *v < limit) {
+			limit = *v
+		}
+	}
+	return limit
+}
+
+// validate 检查参数取值范围，与 OpenAI 的校验规则保持一致
+func (req *OpenAIRequest) validate() *APIError {
+	checkRange := func(name string, v *float64, lo, hi float64) *APIError {
+		if v != nil && (*v < lo || *v > hi) {
+			return errInvalidRequest(fmt.Sprintf("%s must be between %g and %g, got %g", name, lo, hi, *v)).withParam(name)
+		}
+		return nil
+	}
+	if err := checkRange("temperature", req.Temperature, 0, 2); err != nil {
+		return err
+	}
+	if err := checkRange("top_p", req.TopP, 0, 1); err != nil {
+		return err
+	}
+	if err := checkRange("presence_penalty", req.PresencePenalty, -2, 2); err != nil {
+		return err
+	}
+	if err := checkRange("frequency_penalty", req.FrequencyPenalty, -2, 2); err != nil {
+		return err
+	}
+
+	if req.MaxTokens != nil && *req.MaxTokens < 1 {
+		return errInvalidRequest("max_tokens must be at least 1").withParam("max_tokens")
+	}
+	if req.MaxCompletionTokens != nil && *req.MaxCompletionTokens < 1 {
+		return errInvalidRequest("max_completion_tokens must be at least 1").withParam("max_completion_tokens")
+	}
+
+	if len(req.Stop) > maxStopSequences {
+		return errInvalidRequest(fmt.Sprintf("stop may contain at most %d sequences", maxStopSequences)).withParam("stop")
+	}
+	for _, seq := range req.Stop {
+		if seq == "" {
+			return errInvalidRequest("stop sequences must not be empty").withParam("stop")
+		}
+	}
+
+	if req.N != nil {
+		if *req.N < 1 {
+			return errInvalidRequest("n must be at least 1").withParam("n")
+		}
+		if *req.N > maxChoices {
+			return errInvalidRequest(fmt.Sprintf("n must be at most %d", maxChoices)).withParam("n")
+		}
+	}
+
+	if err := validateContent(req.Messages); err != nil {
+		return err
+	}
+	if err := req.validateTools(); err != nil {
+		return err
+	}
+	mode, err := req.validateResponseFormat()
+	if err != nil {
+		return err
+	}
+	req.jsonOutput = mode
+
+	if req.StreamOptions != nil && !req.Stream {
+		return errInvalidRequest("stream_options is only allowed when stream is true").withParam("stream_options")
+	}
+	return nil
+}
+
+// ignoredParams 返回 you.com 无法支持、代理也无法模拟的参数，这些参数会被忽略并在响应头中提示
+func (req *OpenAIRequest) ignoredParams() []string {
+	var ignored []string
+	if req.Temperature != nil {
+		ignored = append(ignored, "temperature")
+	}
+	if req.TopP != nil {
+		ignored = append(ignored, "top_p")
+	}
+	if req.PresencePenalty != nil {
+		ignored = append(ignored, "presence_penalty")
+	}
+	if req.FrequencyPenalty != nil {
+		ignored = append(ignored, "frequency_penalty")
+	}
+	if req.Seed != nil {
+		ignored = append(ignored, "seed")
+	}
+	return ignored
+}
+
+// warnIgnoredParams 将被忽略的参数写入 X-You2Api-Warning 响应头，必须在写响应体之前调用。
+// extra 是其他前端特有的被忽略参数
+func warnIgnoredParams(w http.ResponseWriter, rc *requestContext, req *OpenAIRequest, extra ...string) {
+	ignored := append(req.ignoredParams(), extra...)
+	if len(ignored) == 0 {
+		return
+	}
+	warning := "ignored unsupported parameters: " + strings.Join(ignored, ", ")
+	log.Printf("[%s] %s", rc.RequestID, warning)
+	w.Header().Set(warningHeader, warning)
+}
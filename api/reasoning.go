@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 推理内容（deepseek_r1、o1、o3-mini 的思考过程）的处理方式
+const (
+	reasoningHidden = "hidden" // 丢弃思考过程，只返回回答
+	reasoningSplit  = "split"  // 与 DeepSeek API 一致，通过 reasoning_content 单独返回
+	reasoningInline = "inline" // 以 <think>...</think> 包裹后放在回答前
+)
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// defaultReasoningMode 是部署默认的处理方式，由 REASONING_MODE 配置，默认 split
+var defaultReasoningMode = reasoningModeFromEnv()
+
+func reasoningModeFromEnv() string {
+	mode := os.Getenv("REASONING_MODE")
+	if mode == "" {
+		return reasoningSplit
+	}
+	if !validReasoningMode(mode) {
+		log.Printf("ERROR [reasoning]: invalid REASONING_MODE %q, using %s", mode, reasoningSplit)
+		return reasoningSplit
+	}
+	return mode
+}
+
+func validReasoningMode(mode string) bool {
+	return mode == reasoningHidden || mode == reasoningSplit || mode == reasoningInline
+}
+
+// reasoningMode 返回请求的处理方式，未设置时使用部署默认值
+func (req *OpenAIRequest) reasoningMode() (string, *APIError) {
+	if req.ReasoningMode == "" {
+		return defaultReasoningMode, nil
+	}
+	if !validReasoningMode(req.ReasoningMode) {
+		return "", errInvalidRequest(fmt.Sprintf("reasoning_mode must be one of %s, %s or %s", reasoningHidden, reasoningSplit, reasoningInline)).withParam("reasoning_mode")
+	}
+	return req.ReasoningMode, nil
+}
+
+// reasoningWriter 由支持单独输出思考过程的响应格式实现（目前只有 OpenAI Chat Completions）
+type reasoningWriter interface {
+	writeReasoningDelta(w http.ResponseWriter, rc *requestContext, text string)
+}
+
+// effectiveReasoningMode 返回实际生效的处理方式：响应格式不支持单独输出时，split 退化为 hidden
+func effectiveReasoningMode(rc *requestContext) string {
+	if rc.ReasoningMode != reasoningSplit {
+		return rc.ReasoningMode
+	}
+	if _, ok := rc.Format.(reasoningWriter); ok {
+		return reasoningSplit
+	}
+	return reasoningHidden
+}
+
+// thinkSplitter 从回答文本中分离 <think>...</think> 形式的思考过程。
+// 只有回答以 <think> 开头（允许前导空白）时才视为思考过程，回答中间出现的标签原样保留。
+// 标签可能被拆分在多个增量中，不完整的部分暂存到下一次 Push
+type thinkSplitter struct {
+	state   int    // splitStart、splitThink 或 splitAnswer
+	buf     string // 暂存的文本
+	trimmed bool   // 思考过程结束后是否已经跳过回答开头的空白
+}
+
+const (
+	splitStart = iota
+	splitThink
+	splitAnswer
+)
+
+// Push 输入一段上游文本，返回其中的回答和思考过程
+func (s *thinkSplitter) Push(text string) (answer, reasoning string) {
+	s.buf += text
+	for {
+		switch s.state {
+		case splitStart:
+			rest := strings.TrimLeft(s.buf, " \t\r\n")
+			switch {
+			case rest == "" || (len(rest) < len(thinkOpen) && strings.HasPrefix(thinkOpen, rest)):
+				return answer, reasoning
+			case strings.HasPrefix(rest, thinkOpen):
+				s.state = splitThink
+				s.buf = rest[len(thinkOpen):]
+			default:
+				s.state = splitAnswer
+				s.trimmed = true
+			}
+		case splitThink:
+			if idx := strings.Index(s.buf, thinkClose); idx >= 0 {
+				reasoning += s.buf[:idx]
+				s.buf = s.buf[idx+len(thinkClose):]
+				s.state = splitAnswer
+				continue
+			}
+			keep := partialSuffix(s.buf, thinkClose)
+			reasoning += s.buf[:len(s.buf)-keep]
+			s.buf = s.buf[len(s.buf)-keep:]
+			return answer, reasoning
+		case splitAnswer:
+			if !s.trimmed {
+				s.buf = strings.TrimLeft(s.buf, " \t\r\n")
+				if s.buf == "" {
+					return answer, reasoning
+				}
+				s.trimmed = true
+			}
+			answer += s.buf
+			s.buf = ""
+			return answer, reasoning
+		}
+	}
+}
+
+// Flush 在上游结束时输出暂存的文本，未闭合的 <think> 内容作为思考过程
+func (s *thinkSplitter) Flush() (answer, reasoning string) {
+	rest := s.buf
+	s.buf = ""
+	if s.state == splitThink {
+		return "", rest
+	}
+	if s.state == splitStart {
+		return strings.TrimLeft(rest, " \t\r\n"), ""
+	}
+	return rest, ""
+}
+
+// partialSuffix 返回 s 末尾与 tag 开头相同的最长字节数
+func partialSuffix(s, tag string) int {
+	for n := min(len(tag)-1, len(s)); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// splitReasoning 包装上游事件流：分离回答中的 <think> 标签，再按 mode 处理思考过程。
+// hidden 丢弃思考过程；inline 将其包裹在 <think> 标签中作为回答的一部分，之后的 stop/max_tokens 会计入；
+// split 保留在 Event.Reasoning 中，由响应格式单独输出
+func splitReasoning(events <-chan Event, mode string) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		var splitter thinkSplitter
+		inThink := false // inline 模式下已经输出 <think>，尚未输出 </think>
+
+		emit := func(answer, reasoning string) {
+			switch mode {
+			case reasoningHidden:
+				reasoning = ""
+			case reasoningInline:
+				var b strings.Builder
+				if reasoning != "" && !inThink {
+					b.WriteString(thinkOpen + "\n")
+					inThink = true
+				}
+				b.WriteString(reasoning)
+				if answer != "" && inThink {
+					b.WriteString("\n" + thinkClose + "\n\n")
+					inThink = false
+				}
+				b.WriteString(answer)
+				answer, reasoning = b.String(), ""
+			}
+			if answer != "" || reasoning != "" {
+				out <- Event{Text: answer, Reasoning: reasoning}
+			}
+		}
+
+		for ev := range events {
+			if ev.Err != nil || len(ev.Citations) > 0 {
+				out <- Event{Err: ev.Err, Citations: ev.Citations}
+			}
+			if ev.Err != nil {
+				continue
+			}
+			answer, reasoning := splitter.Push(ev.Text)
+			emit(answer, ev.Reasoning+reasoning)
+		}
+		emit(splitter.Flush())
+		if inThink {
+			out <- Event{Text: "\n" + thinkClose + "\n\n"}
+		}
+	}()
+	return out
+}
+
+// stripThink 去掉整段文本中的 <think> 块，只保留回答
+func stripThink(content string) string {
+	var splitter thinkSplitter
+	answer, _ := splitter.Push(content)
+	rest, _ := splitter.Flush()
+	return answer + rest
+}
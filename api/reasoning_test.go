@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThinkSplitterAcrossChunks(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		wantAnswer    string
+		wantReasoning string
+	}{
+		{"split tags", []string{"  <thi", "nk>plan ", "it</th", "ink>\n\nAnswer", " here"}, "Answer here", "plan it"},
+		{"no think", []string{"Hello ", "<think>x</think>"}, "Hello <think>x</think>", ""},
+		{"unterminated", []string{"<think>still thinking"}, "", "still thinking"},
+		{"partial open only", []string{"<th"}, "<th", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s thinkSplitter
+			var answer, reasoning strings.Builder
+			for _, chunk := range tt.chunks {
+				a, r := s.Push(chunk)
+				answer.WriteString(a)
+				reasoning.WriteString(r)
+			}
+			a, r := s.Flush()
+			answer.WriteString(a)
+			reasoning.WriteString(r)
+			if answer.String() != tt.wantAnswer || reasoning.String() != tt.wantReasoning {
+				t.Errorf("answer = %q, reasoning = %q; want %q, %q", answer.String(), reasoning.String(), tt.wantAnswer, tt.wantReasoning)
+			}
+		})
+	}
+}
+
+func TestChatReasoningModes(t *testing.T) {
+	tests := []struct {
+		mode          string
+		wantContent   string
+		wantReasoning string
+	}{
+		{reasoningSplit, "Answer", "plan"},
+		{reasoningHidden, "Answer", ""},
+		{reasoningInline, "<think>\nplan\n</think>\n\nAnswer", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"<think>pl", "an</think>", "Answer"}})
+
+			body := fmt.Sprintf(`{"model":"deepseek-reasoner","reasoning_mode":%q,"messages":[{"role":"user","content":"hi"}]}`, tt.mode)
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			var resp OpenAIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+				t.Fatalf("invalid response %s: %v", rec.Body, err)
+			}
+			msg := resp.Choices[0].Message
+			if msg.Content != tt.wantContent || msg.ReasoningContent != tt.wantReasoning {
+				t.Errorf("content = %q, reasoning = %q; want %q, %q", msg.Content, msg.ReasoningContent, tt.wantContent, tt.wantReasoning)
+			}
+			if tt.mode == reasoningSplit && (resp.Usage.CompletionTokensDetails == nil || resp.Usage.CompletionTokensDetails.ReasoningTokens == 0) {
+				t.Errorf("usage = %+v, want reasoning tokens", resp.Usage)
+			}
+		})
+	}
+}
+
+func TestChatReasoningStreamDeltas(t *testing.T) {
+	tokens := []string{"<think>plan</think>", "Answer"}
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+	}{
+		{"primary", []Upstream{&MockUpstream{Label: "mock", Tokens: tokens}}},
+		{"fallback", []Upstream{&MockUpstream{Err: errors.New("primary down")}, &MockUpstream{Label: "backup", Tokens: tokens}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withUpstreams(t, tt.upstreams[0], tt.upstreams[1:]...)
+
+			body := `{"model":"deepseek-reasoner","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			var content, reasoning strings.Builder
+			var usage *Usage
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok || data == "[DONE]" {
+					continue
+				}
+				var chunk OpenAIStreamResponse
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatalf("invalid chunk %q: %v", data, err)
+				}
+				if chunk.Usage != nil {
+					usage = chunk.Usage
+				}
+				for _, c := range chunk.Choices {
+					content.WriteString(c.Delta.Content)
+					reasoning.WriteString(c.Delta.ReasoningContent)
+				}
+			}
+			if content.String() != "Answer" || reasoning.String() != "plan" {
+				t.Errorf("content = %q, reasoning = %q", content.String(), reasoning.String())
+			}
+			if usage == nil || usage.CompletionTokensDetails == nil || usage.CompletionTokensDetails.ReasoningTokens == 0 {
+				t.Errorf("usage = %+v, want reasoning tokens matching the streamed reasoning", usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ResponsesRequest 对应 OpenAI Responses API（POST /v1/responses）的请求体
+type ResponsesRequest struct {
+	Model              string            `json:"model"`
+	Input              json.RawMessage   `json:"input"` // 字符串或输入项数组
+	Instructions       string            `json:"instructions,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	Stream             bool              `json:"stream"`
+	MaxOutputTokens    *int              `json:"max_output_tokens,omitempty"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	TopP               *float64          `json:"top_p,omitempty"`
+	Store              *bool             `json:"store,omitempty"` // 默认 true
+	User               string            `json:"user,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tools              json.RawMessage   `json:"tools,omitempty"`
+	ToolChoice         json.RawMessage   `json:"tool_choice,omitempty"`
+	Text               json.RawMessage   `json:"text,omitempty"`
+	Reasoning          json.RawMessage   `json:"reasoning,omitempty"`
+	Truncation         string            `json:"truncation,omitempty"`
+}
+
+// ResponseInputItem 是 input 数组中的一项，代理支持 message 和 function_call_output
+type ResponseInputItem struct {
+	Type    string          `json:"type"` // 省略时视为 message
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // 字符串或 input_text/output_text 片段数组
+	CallID  string          `json:"call_id,omitempty"`
+	Output  string          `json:"output,omitempty"`
+}
+
+// ResponseContentPart 是输出消息中的内容片段
+type ResponseContentPart struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+// ResponseOutputItem 是响应 output 数组中的一项
+type ResponseOutputItem struct {
+	Type    string                `json:"type"`
+	ID      string                `json:"id"`
+	Status  string                `json:"status"`
+	Role    string                `json:"role"`
+	Content []ResponseContentPart `json:"content"`
+}
+
+// ResponseUsage 对应 Responses API 的 usage
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ResponseIncompleteDetails 说明 status 为 incomplete 的原因
+type ResponseIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+// ResponseObject 是 Responses API 的 response 对象，流式事件中也使用该结构
+type ResponseObject struct {
+	ID                 string                     `json:"id"`
+	Object             string                     `json:"object"`
+	CreatedAt          int64                      `json:"created_at"`
+	Status             string                     `json:"status"` // in_progress、completed、incomplete
+	Error              *APIError                  `json:"error"`
+	IncompleteDetails  *ResponseIncompleteDetails `json:"incomplete_details"`
+	Instructions       *string                    `json:"instructions"`
+	MaxOutputTokens    *int                       `json:"max_output_tokens"`
+	Model              string                     `json:"model"`
+	Output             []ResponseOutputItem       `json:"output"`
+	PreviousResponseID *string                    `json:"previous_response_id"`
+	Temperature        *float64                   `json:"temperature"`
+	TopP               *float64                   `json:"top_p"`
+	Store              bool                       `json:"store"`
+	User               *string                    `json:"user,omitempty"`
+	Metadata           map[string]string          `json:"metadata"`
+	Usage              *ResponseUsage             `json:"usage"`
+}
+
+// ResponsesHandler 处理 Responses API：POST /v1/responses 创建响应，
+// GET 和 DELETE /v1/responses/{id} 读取和删除服务端保存的响应
+func ResponsesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rc := newRequestContext(w, r, "", false)
+	rc.ResponseID = "resp_" + randomID()
+	format := &responsesFormat{itemID: "msg_" + randomID()}
+	rc.Format = format
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/responses"), "/")
+	wantMethod := "POST"
+	if id != "" {
+		wantMethod = "GET"
+		if r.Method == "DELETE" {
+			wantMethod = "DELETE"
+		}
+	}
+	if r.Method != wantMethod {
+		writeAPIError(w, rc, errMethodNotAllowed(r.Method))
+		return
+	}
+
+	apiKey := authenticate(w, r, rc)
+	if apiKey == nil {
+		return
+	}
+
+	if id != "" {
+		serveStoredResponse(w, r, rc, apiKey, id)
+		return
+	}
+
+	var responsesReq ResponsesRequest
+	if err := json.NewDecoder(r.Body).Decode(&responsesReq); err != nil {
+		log.Printf("[%s] Failed to decode request body: %v", rc.RequestID, err)
+		writeAPIError(w, rc, errInvalidRequest("Invalid request body: "+err.Error()))
+		return
+	}
+
+	var history []Message
+	if responsesReq.PreviousResponseID != "" {
+		prev, ok := responses.Get(apiKey.Name, responsesReq.PreviousResponseID)
+		if !ok {
+			writeAPIError(w, rc, errResponseNotFound(responsesReq.PreviousResponseID).withParam("previous_response_id"))
+			return
+		}
+		history = prev.Messages
+	}
+
+	openAIReq, extra, apiErr := responsesReq.toOpenAI(history)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	youModel, apiErr := mapModelName(openAIReq.Model)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	chatReq, apiErr := openAIReq.chatRequest(youModel, apiKey)
+	if apiErr != nil {
+		writeAPIError(w, rc, apiErr)
+		return
+	}
+
+	format.req = &responsesReq
+	format.store = responsesReq.Store == nil || *responsesReq.Store
+	// 保存的对话不含 instructions：与 OpenAI 一致，instructions 不会随 previous_response_id 继承
+	format.conversation = openAIReq.Messages
+	if responsesReq.Instructions != "" {
+		format.conversation = openAIReq.Messages[1:]
+	}
+
+	rc.Model = openAIReq.Model
+	rc.Stream = openAIReq.Stream
+	rc.Key = apiKey
+	rc.Tokenizer = tokenizerFor(youModel)
+	rc.PromptTokens = countPromptTokens(rc.Tokenizer, openAIReq.Messages)
+	rc.Limits = newOutputLimiter(nil, openAIReq.completionLimit(), rc.Tokenizer)
+	log.Printf("[%s] Processing Responses request: key=%s, model=%s, messages=%d, previous=%q, stream=%v",
+		rc.RequestID, apiKey.Name, openAIReq.Model, len(openAIReq.Messages), responsesReq.PreviousResponseID, openAIReq.Stream)
+	warnIgnoredParams(w, rc, &openAIReq, extra...)
+
+	serveChat(w, r, rc, chatReq)
+}
+
+// serveStoredResponse 处理 GET 和 DELETE /v1/responses/{id}
+func serveStoredResponse(w http.ResponseWriter, r *http.Request, rc *requestContext, key *APIKey, id string) {
+	if r.Method == "DELETE" {
+		if !responses.Delete(key.Name, id) {
+			writeAPIError(w, rc, errResponseNotFound(id))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "object": "response", "deleted": true})
+		return
+	}
+
+	stored, ok := responses.Get(key.Name, id)
+	if !ok {
+		writeAPIError(w, rc, errResponseNotFound(id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stored.Response)
+}
+
+// toOpenAI 将 Responses 请求转换为等价的 OpenAIRequest 并校验，history 是 previous_response_id 对应的对话。
+// instructions 作为第一条 system 消息
+func (req *ResponsesRequest) toOpenAI(history []Message) (OpenAIRequest, []string, *APIError) {
+	input, apiErr := decodeResponseInput(req.Input)
+	if apiErr != nil {
+		return OpenAIRequest{}, nil, apiErr
+	}
+	if len(input) == 0 {
+		return OpenAIRequest{}, nil, errInvalidRequest("input is required").withParam("input")
+	}
+
+	openAIReq := OpenAIRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		MaxTokens:   req.MaxOutputTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		User:        req.User,
+	}
+	if req.Instructions != "" {
+		openAIReq.Messages = append(openAIReq.Messages, Message{Role: "system", Content: req.Instructions})
+	}
+	openAIReq.Messages = append(openAIReq.Messages, history...)
+	openAIReq.Messages = append(openAIReq.Messages, input...)
+
+	if apiErr := openAIReq.validate(); apiErr != nil {
+		if apiErr.Param != nil && *apiErr.Param == "max_tokens" {
+			apiErr = errInvalidRequest("max_output_tokens must be at least 1").withParam("max_output_tokens")
+		}
+		return openAIReq, nil, apiErr
+	}
+
+	var extra []string
+	for _, p := range []struct {
+		name string
+		raw  json.RawMessage
+	}{{"tools", req.Tools}, {"tool_choice", req.ToolChoice}, {"text", req.Text}, {"reasoning", req.Reasoning}} {
+		if len(p.raw) > 0 && string(p.raw) != "null" {
+			extra = append(extra, p.name)
+		}
+	}
+	if req.Truncation != "" && req.Truncation != "disabled" {
+		extra = append(extra, "truncation")
+	}
+	return openAIReq, extra, nil
+}
+
+// decodeResponseInput 将 input 转换为消息列表：字符串作为一条 user 消息，
+// message 项按角色转换，function_call_output 作为 tool 消息
+func decodeResponseInput(raw json.RawMessage) ([]Message, *APIError) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, errInvalidRequest("input: " + err.Error()).withParam("input")
+		}
+		return []Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []ResponseInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, errInvalidRequest("input must be a string or an array of input items").withParam("input")
+	}
+
+	var messages []Message
+	for i, item := range items {
+		param := fmt.Sprintf("input[%d]", i)
+		switch item.Type {
+		case "", "message":
+			switch item.Role {
+			case "user", "assistant", "system", "developer":
+			default:
+				return nil, errInvalidRequest(fmt.Sprintf("Invalid role %q", item.Role)).withParam(param + ".role")
+			}
+			text, apiErr := decodeResponseContent(item.Content, param+".content")
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			messages = append(messages, Message{Role: item.Role, Content: text})
+		case "function_call_output":
+			messages = append(messages, Message{Role: "tool", Content: item.Output, ToolCallID: item.CallID})
+		default:
+			return nil, errUnsupportedContent(fmt.Sprintf("Input item type %q is not supported", item.Type)).withParam(param)
+		}
+	}
+	return messages, nil
+}
+
+// decodeResponseContent 解析消息内容：字符串或 input_text/output_text 片段数组，图片和文件返回 unsupported_content
+func decodeResponseContent(raw json.RawMessage, param string) (string, *APIError) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", errInvalidRequest(err.Error()).withParam(param)
+		}
+		return text, nil
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errInvalidRequest("content must be a string or an array of content parts").withParam(param)
+	}
+	var texts []string
+	for j, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			texts = append(texts, part.Text)
+		case "input_image", "input_file":
+			return "", errUnsupportedContent("Image and file inputs are not supported by this proxy: you.com does not accept them through the chat API").withParam(
+				fmt.Sprintf("%s[%d]", param, j))
+		default:
+			return "", errUnsupportedContent(fmt.Sprintf("Content part type %q is not supported", part.Type)).withParam(
+				fmt.Sprintf("%s[%d]", param, j))
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// responsesFormat 是 Responses API 格式。流式响应依次发送 response.created、response.in_progress、
+// response.output_item.added、response.content_part.added、若干 response.output_text.delta、
+// response.output_text.done、response.content_part.done、response.output_item.done、response.completed。
+// 完成时按 store 参数保存响应，供 previous_response_id 和 GET /v1/responses/{id} 使用
+type responsesFormat struct {
+	req          *ResponsesRequest // 请求参数，写回 response 对象；认证或解析失败前为空
+	itemID       string            // 输出消息的 ID
+	store        bool
+	conversation []Message // 本次请求的完整对话，不含 instructions
+
+	started bool // 已经发送 response.created 等开始事件
+	seq     int  // sequence_number
+}
+
+// object 构造 response 对象，status 为 in_progress 时不包含输出和用量
+func (f *responsesFormat) object(rc *requestContext, status, content string) ResponseObject {
+	resp := ResponseObject{
+		ID:        rc.ResponseID,
+		Object:    "response",
+		CreatedAt: rc.Created,
+		Status:    status,
+		Model:     rc.Model,
+		Output:    []ResponseOutputItem{},
+		Store:     f.store,
+	}
+	if req := f.req; req != nil {
+		if req.Instructions != "" {
+			resp.Instructions = &req.Instructions
+		}
+		if req.PreviousResponseID != "" {
+			resp.PreviousResponseID = &req.PreviousResponseID
+		}
+		if req.User != "" {
+			resp.User = &req.User
+		}
+		resp.MaxOutputTokens = req.MaxOutputTokens
+		resp.Temperature = req.Temperature
+		resp.TopP = req.TopP
+		resp.Metadata = req.Metadata
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	if status == "in_progress" {
+		return resp
+	}
+
+	if rc.Limits.FinishReason() == "length" {
+		resp.Status = "incomplete"
+		resp.IncompleteDetails = &ResponseIncompleteDetails{Reason: "max_output_tokens"}
+	}
+	resp.Output = []ResponseOutputItem{f.item(resp.Status, content)}
+	for _, a := range rc.annotations(content) {
+		resp.Output[0].Content[0].Annotations = append(resp.Output[0].Content[0].Annotations, ResponseURLCitation{
+			Type:       a.Type,
+			URL:        a.URLCitation.URL,
+			Title:      a.URLCitation.Title,
+			StartIndex: a.URLCitation.StartIndex,
+			EndIndex:   a.URLCitation.EndIndex,
+		})
+	}
+	usage := rc.usage(content)
+	resp.Usage = &ResponseUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.TotalTokens,
+	}
+	return resp
+}
+
+// item 构造输出消息
+func (f *responsesFormat) item(status, content string) ResponseOutputItem {
+	return ResponseOutputItem{
+		Type:    "message",
+		ID:      f.itemID,
+		Status:  status,
+		Role:    "assistant",
+		Content: []ResponseContentPart{f.part(content)},
+	}
+}
+
+func (f *responsesFormat) part(text string) ResponseContentPart {
+	return ResponseContentPart{Type: "output_text", Text: text, Annotations: []interface{}{}}
+}
+
+// emit 发送一个带 type 和 sequence_number 的事件
+func (f *responsesFormat) emit(w http.ResponseWriter, event string, fields map[string]interface{}) {
+	fields["type"] = event
+	fields["sequence_number"] = f.seq
+	f.seq++
+	writeSSEEvent(w, event, fields)
+}
+
+// begin 发送开始事件，重复调用无副作用
+func (f *responsesFormat) begin(w http.ResponseWriter, rc *requestContext) {
+	if f.started {
+		return
+	}
+	f.started = true
+
+	inProgress := f.object(rc, "in_progress", "")
+	f.emit(w, "response.created", map[string]interface{}{"response": inProgress})
+	f.emit(w, "response.in_progress", map[string]interface{}{"response": inProgress})
+	item := f.item("in_progress", "")
+	item.Content = []ResponseContentPart{}
+	f.emit(w, "response.output_item.added", map[string]interface{}{"output_index": 0, "item": item})
+	f.emit(w, "response.content_part.added", map[string]interface{}{
+		"item_id": f.itemID, "output_index": 0, "content_index": 0, "part": f.part(""),
+	})
+}
+
+func (f *responsesFormat) writeDelta(w http.ResponseWriter, rc *requestContext, text string) {
+	f.begin(w, rc)
+	f.emit(w, "response.output_text.delta", map[string]interface{}{
+		"item_id": f.itemID, "output_index": 0, "content_index": 0, "delta": text,
+	})
+	flush(w)
+}
+
+func (f *responsesFormat) finishStream(w http.ResponseWriter, rc *requestContext, content string) {
+	f.begin(w, rc)
+	resp := f.save(rc, content)
+	f.emit(w, "response.output_text.done", map[string]interface{}{
+		"item_id": f.itemID, "output_index": 0, "content_index": 0, "text": content,
+	})
+	f.emit(w, "response.content_part.done", map[string]interface{}{
+		"item_id": f.itemID, "output_index": 0, "content_index": 0, "part": f.part(content),
+	})
+	f.emit(w, "response.output_item.done", map[string]interface{}{"output_index": 0, "item": resp.Output[0]})
+	event := "response.completed"
+	if resp.Status == "incomplete" {
+		event = "response.incomplete"
+	}
+	f.emit(w, event, map[string]interface{}{"response": resp})
+	flush(w)
+}
+
+func (f *responsesFormat) writeResponse(w http.ResponseWriter, rc *requestContext, content string) {
+	resp := f.save(rc, content)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// save 构造最终的 response 对象，store 为 true 时连同对话一起保存
+func (f *responsesFormat) save(rc *requestContext, content string) ResponseObject {
+	resp := f.object(rc, "completed", content)
+	if f.store {
+		messages := make([]Message, 0, len(f.conversation)+1)
+		messages = append(messages, f.conversation...)
+		messages = append(messages, Message{Role: "assistant", Content: content})
+		responses.Put(resp.ID, &storedResponse{Key: rc.Key.Name, Response: resp, Messages: messages})
+	}
+	return resp
+}
+
+// writeError 在流式响应开始前写出 OpenAI 格式的错误，之后发送 error 事件
+func (f *responsesFormat) writeError(w http.ResponseWriter, rc *requestContext, apiErr *APIError) {
+	if rc == nil || !rc.streamStarted {
+		openAIFormat{}.writeError(w, rc, apiErr)
+		return
+	}
+	fields := map[string]interface{}{"message": apiErr.Message, "code": apiErr.Code, "param": apiErr.Param}
+	f.emit(w, "error", fields)
+	flush(w)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postResponses 向 /v1/responses 发送请求
+func postResponses(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	Handler(rec, req)
+	return rec
+}
+
+func TestResponsesPreviousResponseChaining(t *testing.T) {
+	old := responses
+	responses = newResponseStore(10, time.Hour)
+	t.Cleanup(func() { responses = old })
+
+	var got []ChatRequest
+	withUpstreams(t, &MockUpstream{Label: "mock", Reply: func(req ChatRequest) []string {
+		got = append(got, req)
+		return []string{fmt.Sprintf("answer %d", len(got))}
+	}})
+
+	rec := postResponses(t, `{"model":"gpt-4o","instructions":"be brief","input":"first question"}`)
+	var first ResponseObject
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if first.Status != "completed" || len(first.Output) != 1 || first.Output[0].Content[0].Text != "answer 1" {
+		t.Fatalf("unexpected first response %s", rec.Body)
+	}
+
+	body := fmt.Sprintf(`{"model":"gpt-4o","previous_response_id":%q,"input":[{"role":"user","content":[{"type":"input_text","text":"second question"}]}]}`, first.ID)
+	rec = postResponses(t, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	second := got[1]
+	if second.Query != "second question" {
+		t.Errorf("query = %q, want second question", second.Query)
+	}
+	wantHistory := []ChatTurn{{Question: "first question", Answer: "answer 1"}}
+	if len(second.History) != 1 || second.History[0] != wantHistory[0] {
+		t.Errorf("history = %+v, want %+v", second.History, wantHistory)
+	}
+	if second.Instructions != "" {
+		t.Errorf("instructions = %q, want them not inherited", second.Instructions)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/responses/"+first.ID, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec = httptest.NewRecorder()
+	Handler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), first.ID) {
+		t.Errorf("GET stored response: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = postResponses(t, `{"model":"gpt-4o","previous_response_id":"resp_missing","input":"hi"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown previous_response_id: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestResponsesStreamEvents(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"Hello", ", world"}})
+
+	rec := postResponses(t, `{"model":"gpt-4o","input":"hi","stream":true,"store":false}`)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var events []string
+	for _, m := range regexp.MustCompile(`(?m)^event: (\S+)$`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		events = append(events, m[1])
+	}
+	want := "response.created response.in_progress response.output_item.added response.content_part.added " +
+		"response.output_text.delta response.output_text.delta response.output_text.done " +
+		"response.content_part.done response.output_item.done response.completed"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("events = %s\nwant     %s", got, want)
+	}
+	if !strings.Contains(rec.Body.String(), `"text":"Hello, world"`) {
+		t.Errorf("missing final text in %s", rec.Body)
+	}
+}
+
+func TestDecodeResponseInputFunctionCallOutput(t *testing.T) {
+	messages, apiErr := decodeResponseInput(json.RawMessage(`[{"role":"user","content":"weather?"},{"type":"function_call_output","call_id":"call_1","output":"sunny"}]`))
+	if apiErr != nil || len(messages) != 2 {
+		t.Fatalf("messages = %+v, %v", messages, apiErr)
+	}
+	if got := messages[1]; got.Role != "tool" || got.Content != "sunny" || got.ToolCallID != "call_1" {
+		t.Errorf("tool message = %+v, want call_1 linked", got)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultResponseStoreSize = 1000
+	defaultResponseStoreTTL  = 24 * time.Hour
+)
+
+// storedResponse 是保存在服务端的一次 Responses API 结果
+type storedResponse struct {
+	Key      string         // 创建该响应的密钥名，其他密钥不可读取
+	Response ResponseObject // 返回给客户端的响应对象
+	Messages []Message      // 截至本次回答的完整对话（不含 instructions），供 previous_response_id 续接
+	Expires  time.Time
+}
+
+// responseStore 在内存中保存 Responses API 的结果，使客户端只需传 previous_response_id 即可续接对话。
+//
+//	RESPONSES_STORE_SIZE  最多保存的响应数，超出时淘汰最早的，默认 1000
+//	RESPONSES_STORE_TTL   保存时长，Go duration 格式，默认 24h
+//
+// 数据只保存在当前进程中：独立服务重启后丢失，Vercel 上不同实例之间也不共享。
+type responseStore struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*storedResponse
+	order   []string // 按写入顺序排列的 ID，用于淘汰
+}
+
+func newResponseStore(size int, ttl time.Duration) *responseStore {
+	return &responseStore{size: size, ttl: ttl, entries: make(map[string]*storedResponse)}
+}
+
+// newResponseStoreFromEnv 读取 RESPONSES_STORE_SIZE 和 RESPONSES_STORE_TTL
+func newResponseStoreFromEnv() *responseStore {
+	size := defaultResponseStoreSize
+	if v := os.Getenv("RESPONSES_STORE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			size = n
+		} else {
+			log.Printf("ERROR [responses]: invalid RESPONSES_STORE_SIZE %q, using %d", v, size)
+		}
+	}
+	ttl := defaultResponseStoreTTL
+	if v := os.Getenv("RESPONSES_STORE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			ttl = d
+		} else {
+			log.Printf("ERROR [responses]: invalid RESPONSES_STORE_TTL %q, using %s", v, ttl)
+		}
+	}
+	return newResponseStore(size, ttl)
+}
+
+// Put 保存响应，同时清理过期和超出容量的条目
+func (s *responseStore) Put(id string, entry *storedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry.Expires = now.Add(s.ttl)
+	if _, exists := s.entries[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.entries[id] = entry
+
+	for len(s.order) > 0 {
+		oldest, ok := s.entries[s.order[0]]
+		if ok && len(s.entries) <= s.size && now.Before(oldest.Expires) {
+			break
+		}
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get 返回 key 可以访问的未过期响应
+func (s *responseStore) Get(key, id string) (*storedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok || entry.Key != key || time.Now().After(entry.Expires) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Delete 删除 key 创建的响应，返回是否存在
+func (s *responseStore) Delete(key, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok || entry.Key != key {
+		return false
+	}
+	delete(s.entries, id)
+	for i, v := range s.order {
+		if v == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// responses 是全局的 Responses API 存储，测试中可以替换
+var responses = newResponseStoreFromEnv()
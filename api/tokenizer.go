@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer 估算文本的 token 数。you.com 不返回用量，代理只能按模型家族近似计算
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+	// Counter 返回增量计数器，用于逐段输入的流式输出
+	Counter() TokenCounter
+}
+
+// TokenCounter 逐段累计 token 数，Total 等于对已输入文本整体调用 Count 的结果
+type TokenCounter interface {
+	Add(text string)
+	Total() int
+	Clone() TokenCounter
+}
+
+// Usage 对应 OpenAI 响应中的 usage
+type Usage struct {
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// CompletionTokensDetails 对应 usage.completion_tokens_details，completion_tokens 已包含其中的思考 token
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// bpeApprox 近似 BPE 分词：先按 GPT 的预分词规则切分（单词、数字、标点、空白），
+// 再按家族的平均字符数估算每段的 token 数。中日韩文字单独按每字系数计算
+type bpeApprox struct {
+	name       string
+	shortWord  int     // 不超过该长度的单词计为 1 个 token
+	wordChars  float64 // 较长单词平均每个 token 的字符数
+	cjkPerChar float64 // 每个中日韩字符的 token 数
+}
+
+var (
+	// o200kTokenizer 对应 gpt-4o / o1 / o3 使用的 o200k_base，词表更大，中文更省
+	o200kTokenizer = &bpeApprox{name: "o200k-approx", shortWord: 7, wordChars: 4.2, cjkPerChar: 0.9}
+	// cl100kTokenizer 对应 gpt-4-turbo / gpt-3.5 使用的 cl100k_base
+	cl100kTokenizer = &bpeApprox{name: "cl100k-approx", shortWord: 6, wordChars: 4.0, cjkPerChar: 1.3}
+	// claudeTokenizer 对应 Claude 3 系列，英文切分略细
+	claudeTokenizer = &bpeApprox{name: "claude-approx", shortWord: 6, wordChars: 3.5, cjkPerChar: 1.2}
+	// cjkTokenizer 是其他模型（DeepSeek、Qwen、Gemini、Llama 等）的通用估算，这些模型中文词表较大
+	cjkTokenizer = &bpeApprox{name: "cjk-approx", shortWord: 6, wordChars: 4.0, cjkPerChar: 0.75}
+)
+
+// tokenizerFor 根据 you.com 模型 ID 选择估算器
+func tokenizerFor(youModel string) Tokenizer {
+	switch {
+	case strings.HasPrefix(youModel, "gpt_4o"), strings.HasPrefix(youModel, "openai_o"):
+		return o200kTokenizer
+	case strings.HasPrefix(youModel, "gpt_"):
+		return cl100kTokenizer
+	case strings.HasPrefix(youModel, "claude"):
+		return claudeTokenizer
+	default:
+		return cjkTokenizer
+	}
+}
+
+func (t *bpeApprox) Name() string {
+	return t.name
+}
+
+// charClass 是预分词使用的字符类别
+type charClass int
+
+const (
+	classSpace charClass = iota
+	classLetter
+	classDigit
+	classCJK
+	classOther
+)
+
+func classify(r rune) charClass {
+	switch {
+	case unicode.IsSpace(r):
+		return classSpace
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return classCJK
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return classLetter
+	case unicode.IsDigit(r):
+		return classDigit
+	default:
+		return classOther
+	}
+}
+
+func (t *bpeApprox) Count(text string) int {
+	c := t.Counter()
+	c.Add(text)
+	return c.Total()
+}
+
+// Counter 返回一个空的增量计数器
+func (t *bpeApprox) Counter() TokenCounter {
+	return &bpeCounter{t: t, class: classSpace}
+}
+
+// bpeCounter 是 bpeApprox 的增量计数状态，保存尚未结束的片段，因此分段输入和一次输入的结果相同
+type bpeCounter struct {
+	t       *bpeApprox
+	tokens  float64
+	class   charClass
+	run     int  // 当前片段的字符数
+	newline bool // 当前空白片段是否包含换行
+}
+
+func (c *bpeCounter) Add(text string) {
+	for _, r := range text {
+		if class := classify(r); class != c.class {
+			c.flush()
+			c.class = class
+		}
+		c.run++
+		if r == '\n' {
+			c.newline = true
+		}
+	}
+}
+
+func (c *bpeCounter) Total() int {
+	end := *c
+	end.flush()
+	return int(math.Ceil(end.tokens))
+}
+
+func (c *bpeCounter) Clone() TokenCounter {
+	clone := *c
+	return &clone
+}
+
+// flush 结束当前片段并计入 token 数
+func (c *bpeCounter) flush() {
+	t := c.t
+	switch c.class {
+	case classSpace:
+		// 单个空格并入后面的单词（" word" 是一个 token），换行和连续空白单独计数
+		if c.newline || c.run > 1 {
+			c.tokens++
+		}
+	case classLetter:
+		if c.run <= t.shortWord {
+			c.tokens++
+		} else {
+			c.tokens += math.Ceil(float64(c.run) / t.wordChars)
+		}
+	case classDigit:
+		// 数字按最多 3 位一组切分
+		c.tokens += math.Ceil(float64(c.run) / 3)
+	case classCJK:
+		c.tokens += math.Max(1, float64(c.run)*t.cjkPerChar)
+	case classOther:
+		c.tokens += math.Ceil(float64(c.run) / 2)
+	}
+	c.run = 0
+	c.newline = false
+}
+
+// countPromptTokens 按 OpenAI 的消息格式估算 prompt token：每条消息 3 个格式 token 加角色和内容，
+// 回复前缀另加 3 个
+func countPromptTokens(t Tokenizer, messages []Message) int {
+	total := 3
+	for _, msg := range messages {
+		total += 3 + t.Count(msg.Role) + t.Count(msg.Content)
+	}
+	return total
+}
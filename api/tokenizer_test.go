@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizerCount(t *testing.T) {
+	tests := []struct {
+		tokenizer *bpeApprox
+		text      string
+		want      int
+	}{
+		{cl100kTokenizer, "", 0},
+		{cl100kTokenizer, "hello", 1},
+		{cl100kTokenizer, "hello world", 2},          // 单个空格并入后面的单词
+		{cl100kTokenizer, "hello\n\nworld", 3},       // 换行单独计数
+		{cl100kTokenizer, "internationalization", 5}, // 20 个字母 / 4.0
+		{o200kTokenizer, "internationalization", 5},  // 20 / 4.2 向上取整
+		{cl100kTokenizer, "1234567", 3},              // 数字按 3 位一组
+		{cl100kTokenizer, "!?", 1},
+		{cl100kTokenizer, "你好世界", 6},  // 4 * 1.3 向上取整
+		{o200kTokenizer, "你好世界", 4},   // 4 * 0.9 向上取整
+		{cjkTokenizer, "你好世界", 3},     // 4 * 0.75
+		{cjkTokenizer, "好", 1},        // 至少 1 个
+		{claudeTokenizer, "こんにちは", 6}, // 5 * 1.2
+	}
+	for _, tt := range tests {
+		if got := tt.tokenizer.Count(tt.text); got != tt.want {
+			t.Errorf("%s.Count(%q) = %d, want %d", tt.tokenizer.Name(), tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizerMonotonic(t *testing.T) {
+	texts := []string{
+		"", " ", "\n", "a", "word", "supercalifragilistic", "42", "3.14159", "!!", "你好", "世界，你好。",
+		"Hello, world!", "mixed 中文 and English 123", "  leading spaces", "trailing\n\n",
+	}
+	for _, tokenizer := range []*bpeApprox{o200kTokenizer, cl100kTokenizer, claudeTokenizer, cjkTokenizer} {
+		for _, a := range texts {
+			for _, b := range texts {
+				ab := tokenizer.Count(a + b)
+				if ab < tokenizer.Count(a) || ab < tokenizer.Count(b) {
+					t.Errorf("%s: Count(%q+%q) = %d is less than a part (%d, %d)", tokenizer.Name(), a, b, ab, tokenizer.Count(a), tokenizer.Count(b))
+				}
+			}
+		}
+	}
+}
+
+func TestTokenCounterMatchesCount(t *testing.T) {
+	text := "The quick brown fox, 1234567 times!\n\n你好世界 internationalization  ok"
+	for _, tokenizer := range []*bpeApprox{o200kTokenizer, cl100kTokenizer, claudeTokenizer, cjkTokenizer} {
+		c := tokenizer.Counter()
+		for i, r := range text {
+			c.Add(string(r))
+			if got, want := c.Total(), tokenizer.Count(text[:i+len(string(r))]); got != want {
+				t.Fatalf("%s: counter total %d after %q, Count = %d", tokenizer.Name(), got, text[:i+len(string(r))], want)
+			}
+		}
+
+		// Clone 之后的输入不影响原计数器
+		clone := c.Clone()
+		clone.Add(strings.Repeat(" more", 10))
+		if c.Total() != tokenizer.Count(text) || clone.Total() <= c.Total() {
+			t.Errorf("%s: clone shares state with the original", tokenizer.Name())
+		}
+	}
+}
+
+func TestTokenizerFor(t *testing.T) {
+	tests := map[string]*bpeApprox{
+		"gpt_4o":                o200kTokenizer,
+		"openai_o3_mini_medium": o200kTokenizer,
+		"gpt_4_turbo":           cl100kTokenizer,
+		"claude_3_5_sonnet":     claudeTokenizer,
+		"deepseek_r1":           cjkTokenizer,
+	}
+	for youModel, want := range tests {
+		if got := tokenizerFor(youModel); got != Tokenizer(want) {
+			t.Errorf("tokenizerFor(%q) = %s, want %s", youModel, got.Name(), want.Name())
+		}
+	}
+}
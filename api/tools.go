@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// you.com 没有函数调用接口，代理把工具定义写入系统提示，要求模型在需要调用工具时输出
+// <tool_calls>[{"name":...,"arguments":{...}}]</tool_calls>，再从回答中解析出 tool_calls
+const (
+	toolCallsOpen  = "<tool_calls>"
+	toolCallsClose = "</tool_calls>"
+)
+
+// Tool 对应请求中 tools 的一项，目前只支持 function
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 是函数的定义
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// ToolCall 对应 assistant 消息中 tool_calls 的一项，流式增量中 Index 有效
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 是被调用的函数名和 JSON 字符串形式的参数
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolChoice 对应 tool_choice：字符串 none、auto、required，或 {"type":"function","function":{"name":...}}
+type ToolChoice struct {
+	Mode     string // none、auto 或 required；指定函数时为 required
+	Function string // 指定的函数名
+}
+
+func (c *ToolChoice) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &c.Mode)
+	}
+	var named struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil || named.Type != "function" {
+		return fmt.Errorf(`tool_choice must be "none", "auto", "required" or {"type":"function","function":{"name":...}}`)
+	}
+	c.Mode, c.Function = "required", named.Function.Name
+	return nil
+}
+
+// toolSet 是一次请求中生效的工具配置
+type toolSet struct {
+	Tools    []Tool
+	Choice   ToolChoice
+	Parallel bool // parallel_tool_calls，false 时最多保留一个调用
+}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// validateTools 检查 tools 和 tool_choice
+func (req *OpenAIRequest) validateTools() *APIError {
+	names := make(map[string]bool, len(req.Tools))
+	for i, tool := range req.Tools {
+		param := fmt.Sprintf("tools[%d]", i)
+		if tool.Type != "function" {
+			return errInvalidRequest(fmt.Sprintf("Tool type %q is not supported, only function", tool.Type)).withParam(param + ".type")
+		}
+		if !toolNamePattern.MatchString(tool.Function.Name) {
+			return errInvalidRequest("Function name must be 1-64 characters of a-z, A-Z, 0-9, underscores and dashes").withParam(param + ".function.name")
+		}
+		if len(tool.Function.Parameters) > 0 && !json.Valid(tool.Function.Parameters) {
+			return errInvalidRequest("function.parameters must be a JSON Schema object").withParam(param + ".function.parameters")
+		}
+		names[tool.Function.Name] = true
+	}
+
+	if req.ToolChoice == nil {
+		return nil
+	}
+	switch req.ToolChoice.Mode {
+	case "none", "auto", "required":
+	default:
+		return errInvalidRequest(`tool_choice must be "none", "auto", "required" or a function`).withParam("tool_choice")
+	}
+	if req.ToolChoice.Mode != "none" && len(req.Tools) == 0 {
+		return errInvalidRequest("tool_choice is only allowed when tools are specified").withParam("tool_choice")
+	}
+	if req.ToolChoice.Function != "" && !names[req.ToolChoice.Function] {
+		return errInvalidRequest(fmt.Sprintf("tool_choice names function %q, which is not in tools", req.ToolChoice.Function)).withParam("tool_choice")
+	}
+	return nil
+}
+
+// toolSet 返回生效的工具配置，没有工具或 tool_choice 为 none 时返回 nil
+func (req *OpenAIRequest) toolSet() *toolSet {
+	if len(req.Tools) == 0 {
+		return nil
+	}
+	set := &toolSet{Tools: req.Tools, Choice: ToolChoice{Mode: "auto"}, Parallel: true}
+	if req.ToolChoice != nil {
+		set.Choice = *req.ToolChoice
+	}
+	if set.Choice.Mode == "none" {
+		return nil
+	}
+	if req.ParallelToolCalls != nil {
+		set.Parallel = *req.ParallelToolCalls
+	}
+	return set
+}
+
+// Prompt 返回写入系统提示的工具说明
+func (s *toolSet) Prompt() string {
+	var b strings.Builder
+	b.WriteString("You can call the following tools. Each tool is described by its name, description and JSON Schema parameters:\n\n")
+	for _, tool := range s.Tools {
+		fmt.Fprintf(&b, "- name: %s\n", tool.Function.Name)
+		if tool.Function.Description != "" {
+			fmt.Fprintf(&b, "  description: %s\n", tool.Function.Description)
+		}
+		if len(tool.Function.Parameters) > 0 {
+			var compact bytes.Buffer
+			if json.Compact(&compact, tool.Function.Parameters) == nil {
+				fmt.Fprintf(&b, "  parameters: %s\n", compact.String())
+			}
+		}
+	}
+	b.WriteString("\nTo call tools, reply with exactly one block in this format and nothing after it:\n")
+	b.WriteString(toolCallsOpen + "\n" + `[{"name": "tool_name", "arguments": {"arg": "value"}}]` + "\n" + toolCallsClose + "\n")
+	b.WriteString("The arguments must be a JSON object matching the tool's parameters. Do not wrap the block in a code fence. ")
+	b.WriteString("Results will be sent back in messages starting with [Tool result]. ")
+
+	switch {
+	case s.Choice.Function != "":
+		fmt.Fprintf(&b, "You must call the tool %s now.", s.Choice.Function)
+	case s.Choice.Mode == "required":
+		b.WriteString("You must call at least one tool now.")
+	default:
+		b.WriteString("Only call a tool when it is needed; otherwise answer normally without the block.")
+	}
+	if !s.Parallel {
+		b.WriteString(" Call at most one tool per reply.")
+	}
+	return b.String()
+}
+
+// renderToolCalls 将 assistant 消息中的 tool_calls 还原为模型输出的格式，用于历史对话
+func renderToolCalls(calls []ToolCall) string {
+	type call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	var list []call
+	for _, c := range calls {
+		args := json.RawMessage(c.Function.Arguments)
+		if !json.Valid(args) {
+			args, _ = json.Marshal(c.Function.Arguments)
+		}
+		list = append(list, call{Name: c.Function.Name, Arguments: args})
+	}
+	data, _ := json.Marshal(list)
+	return toolCallsOpen + "\n" + string(data) + "\n" + toolCallsClose
+}
+
+// parseToolCalls 解析 <tool_calls> 块中的 JSON，接受数组或单个对象，arguments 可以是对象或 JSON 字符串
+func parseToolCalls(block string) ([]ToolCall, error) {
+	block = strings.TrimSpace(block)
+	block = strings.TrimPrefix(block, "```json")
+	block = strings.Trim(block, "`\n ")
+	if strings.HasPrefix(block, "{") {
+		block = "[" + block + "]"
+	}
+
+	var raw []struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(block), &raw); err != nil {
+		return nil, err
+	}
+
+	var calls []ToolCall
+	for _, r := range raw {
+		if r.Name == "" {
+			return nil, fmt.Errorf("tool call without a name")
+		}
+		args := strings.TrimSpace(string(r.Arguments))
+		if args == "" || args == "null" {
+			args = "{}"
+		} else if args[0] == '"' {
+			// 参数被写成了 JSON 字符串
+			json.Unmarshal(r.Arguments, &args)
+		}
+		calls = append(calls, ToolCall{
+			ID:       "call_" + randomID(),
+			Type:     "function",
+			Function: ToolCallFunction{Name: r.Name, Arguments: args},
+		})
+	}
+	return calls, nil
+}
+
+// toolCallExtractor 从回答中分离 <tool_calls> 块。块之前的文本照常输出，
+// 块开始后的内容暂存到上游结束再解析；解析失败时按普通文本输出
+type toolCallExtractor struct {
+	set     *toolSet
+	buf     string // 可能是块开头的尾部文本，或块开始后的全部内容
+	inBlock bool
+}
+
+// Push 输入一段回答，返回可以直接输出的文本
+func (x *toolCallExtractor) Push(text string) string {
+	x.buf += text
+	if x.inBlock {
+		return ""
+	}
+	if idx := strings.Index(x.buf, toolCallsOpen); idx >= 0 {
+		out := x.buf[:idx]
+		x.buf = x.buf[idx:]
+		x.inBlock = true
+		return out
+	}
+	keep := partialSuffix(x.buf, toolCallsOpen)
+	out := x.buf[:len(x.buf)-keep]
+	x.buf = x.buf[len(x.buf)-keep:]
+	return out
+}
+
+// Flush 在上游结束时解析暂存的块，返回剩余文本和工具调用
+func (x *toolCallExtractor) Flush() (string, []ToolCall) {
+	rest := x.buf
+	x.buf = ""
+	if !x.inBlock {
+		return rest, nil
+	}
+
+	block := strings.TrimPrefix(rest, toolCallsOpen)
+	if end := strings.Index(block, toolCallsClose); end >= 0 {
+		block = block[:end]
+	}
+	calls, err := parseToolCalls(block)
+	if err != nil || len(calls) == 0 {
+		log.Printf("WARN [tools]: failed to parse tool call block, returning it as text: %v", err)
+		return rest, nil
+	}
+	if !x.set.Parallel && len(calls) > 1 {
+		calls = calls[:1]
+	}
+	return "", calls
+}
+
+// extractToolCalls 包装上游事件流，将回答中的 <tool_calls> 块转换为 Event.ToolCalls
+func extractToolCalls(events <-chan Event, set *toolSet) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		x := &toolCallExtractor{set: set}
+		for ev := range events {
+			if ev.Text != "" {
+				ev.Text = x.Push(ev.Text)
+			}
+			if ev.Err != nil || ev.Text != "" || ev.Reasoning != "" || len(ev.Citations) > 0 {
+				out <- ev
+			}
+		}
+		if text, calls := x.Flush(); text != "" || len(calls) > 0 {
+			out <- Event{Text: text, ToolCalls: calls}
+		}
+	}()
+	return out
+}
+
+// toolCallWriter 由支持输出工具调用的响应格式实现（目前只有 OpenAI Chat Completions）
+type toolCallWriter interface {
+	writeToolCallDeltas(w http.ResponseWriter, rc *requestContext, calls []ToolCall)
+}
+
+// toolArgumentChunk 是流式响应中每个参数增量的最大字节数
+const toolArgumentChunk = 32
+
+// argumentChunks 将参数按字符边界切分为若干增量
+func argumentChunks(args string) []string {
+	var chunks []string
+	for len(args) > toolArgumentChunk {
+		n := toolArgumentChunk
+		for n > 0 && !isRuneStart(args[n]) {
+			n--
+		}
+		chunks = append(chunks, args[:n])
+		args = args[n:]
+	}
+	return append(chunks, args)
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const weatherTools = `"tools":[{"type":"function","function":{"name":"get_weather","description":"Current weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}]`
+
+func TestToolCallExtractorAcrossChunks(t *testing.T) {
+	x := &toolCallExtractor{set: &toolSet{Parallel: true}}
+	var text strings.Builder
+	for _, chunk := range []string{"Let me check. <tool", "_calls>\n[{\"name\":\"get_weather\",", "\"arguments\":{\"city\":\"Paris\"}}]\n</tool_calls>"} {
+		text.WriteString(x.Push(chunk))
+	}
+	rest, calls := x.Flush()
+	text.WriteString(rest)
+
+	if text.String() != "Let me check. " {
+		t.Errorf("text = %q", text.String())
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"Paris"}` || !strings.HasPrefix(calls[0].ID, "call_") {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestToolCallExtractorInvalidBlock(t *testing.T) {
+	var text strings.Builder
+	var calls []ToolCall
+	for ev := range extractToolCalls(textEvents("<tool_calls>not json</tool_calls>"), &toolSet{Parallel: true}) {
+		text.WriteString(ev.Text)
+		calls = append(calls, ev.ToolCalls...)
+	}
+	if len(calls) != 0 || text.String() != "<tool_calls>not json</tool_calls>" {
+		t.Errorf("text = %q, calls = %+v", text.String(), calls)
+	}
+}
+
+func TestChatToolCalls(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"<tool_calls>[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}},", "{\"name\":\"get_weather\",\"arguments\":\"{\\\"city\\\":\\\"Rome\\\"}\"}]</tool_calls>"}})
+
+	body := `{"model":"gpt-4o","parallel_tool_calls":false,` + weatherTools + `,"messages":[{"role":"user","content":"weather?"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content   *string    `json:"content"`
+				ToolCalls []ToolCall `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Choices) != 1 {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason != "tool_calls" || choice.Message.Content != nil {
+		t.Errorf("finish_reason = %q, content = %v", choice.FinishReason, choice.Message.Content)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool_calls = %+v, want only the first call", choice.Message.ToolCalls)
+	}
+}
+
+func TestChatToolCallsStream(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Label: "mock", Tokens: []string{"<tool_calls>[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"A very long city name that spans chunks\"}}]</tool_calls>"}})
+
+	body := `{"model":"gpt-4o","stream":true,` + weatherTools + `,"messages":[{"role":"user","content":"weather?"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var name, args, finish string
+	deltas := 0
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, c := range chunk.Choices {
+			for _, call := range c.Delta.ToolCalls {
+				if call.Index == nil || *call.Index != 0 {
+					t.Errorf("tool call delta without index 0: %s", data)
+				}
+				name += call.Function.Name
+				args += call.Function.Arguments
+				deltas++
+			}
+			if c.Delta.Content != "" {
+				t.Errorf("unexpected content: %s", data)
+			}
+			finish += c.FinishReason
+		}
+	}
+	if name != "get_weather" || args != `{"city":"A very long city name that spans chunks"}` || deltas < 3 {
+		t.Errorf("name = %q, args = %q over %d deltas", name, args, deltas)
+	}
+	if finish != "tool_calls" {
+		t.Errorf("finish_reason = %q", finish)
+	}
+}
+
+func TestChatToolCallsStreamFallback(t *testing.T) {
+	withUpstreams(t, &MockUpstream{Err: errors.New("primary down")},
+		&MockUpstream{Label: "backup", Tokens: []string{"<tool_calls>[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}]</tool_calls>"}})
+
+	body := `{"model":"gpt-4o","stream":true,` + weatherTools + `,"messages":[{"role":"user","content":"weather?"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	var name, args, content, finish string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, c := range chunk.Choices {
+			for _, call := range c.Delta.ToolCalls {
+				name += call.Function.Name
+				args += call.Function.Arguments
+			}
+			content += c.Delta.Content
+			finish += c.FinishReason
+		}
+	}
+	if name != "get_weather" || args != `{"city":"Paris"}` || content != "" || finish != "tool_calls" {
+		t.Errorf("name = %q, args = %q, content = %q, finish_reason = %q", name, args, content, finish)
+	}
+}
+
+func TestToolValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		param string
+	}{
+		{"bad name", `{"tools":[{"type":"function","function":{"name":"get weather"}}]}`, "tools[0].function.name"},
+		{"bad type", `{"tools":[{"type":"retrieval","function":{"name":"x"}}]}`, "tools[0].type"},
+		{"unknown choice", `{"tools":[{"type":"function","function":{"name":"x"}}],"tool_choice":{"type":"function","function":{"name":"y"}}}`, "tool_choice"},
+		{"choice without tools", `{"tool_choice":"required"}`, "tool_choice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			apiErr := req.validateTools()
+			if apiErr == nil || apiErr.Param == nil || *apiErr.Param != tt.param {
+				t.Errorf("got %v, want error on %s", apiErr, tt.param)
+			}
+		})
+	}
+}
+
+func TestFoldToolMessages(t *testing.T) {
+	assistant := foldMessage(Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"Paris"}`}}}})
+	if assistant != "<tool_calls>\n[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}]\n</tool_calls>" {
+		t.Errorf("assistant = %q", assistant)
+	}
+	if got := foldMessage(Message{Role: "tool", ToolCallID: "call_1", Content: "sunny"}); got != "[Tool result for call_1]\nsunny" {
+		t.Errorf("tool = %q", got)
+	}
+}
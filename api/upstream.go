@@ -0,0 +1,522 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"you2api/internal/sse"
+)
+
+const (
+	youEndpoint = "https://you.com/api/streamingSearch"
+	corsShProxy = "https://proxy.cors.sh/"
+	corsShKey   = "live_a48b9b66e68b4b0bb41a3df6de21e59b4a28cfc55b1343a0b0b0f5b5c2e8e8c7"
+)
+
+// ChatTurn 是 you.com chat 参数中的一轮对话
+type ChatTurn struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// ChatRequest 是发往上游的一次聊天请求
+type ChatRequest struct {
+	Model        string       // you.com 模型 ID，如 gpt_4o
+	Query        string       // 当前问题
+	History      []ChatTurn   // 历史对话
+	Instructions string       // 系统提示，为空表示没有
+	Options      *YouSettings // 搜索和模式设置，为空时使用部署默认值
+}
+
+// settings 返回本次请求的 you.com 设置
+func (req ChatRequest) settings() YouSettings {
+	if req.Options != nil {
+		return *req.Options
+	}
+	return defaultYouSettings
+}
+
+// prompt 返回实际发送的 q：you.com 没有指令参数，系统提示按模板拼接在当前问题前
+func (req ChatRequest) prompt() string {
+	return defaultSystemPrompts.Render(req.Instructions, req.Query)
+}
+
+// Event 是上游流中的一个事件，Err 非空表示读取中断
+type Event struct {
+	Text      string
+	Reasoning string     // 推理模型的思考过程，与 Text 分开传递
+	Citations []Citation // 搜索结果中的来源
+	ToolCalls []ToolCall // 从回答中解析出的工具调用
+	Err       error
+}
+
+// Upstream 是聊天内容的来源，Stream 返回的通道在上游结束或 ctx 取消后关闭
+type Upstream interface {
+	Name() string
+	Stream(ctx context.Context, req ChatRequest) (<-chan Event, error)
+}
+
+// UpstreamError 表示上游返回了非 200 状态码
+type UpstreamError struct {
+	Upstream   string
+	StatusCode int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream %s returned status %d", e.Upstream, e.StatusCode)
+}
+
+// YouUpstream 直接请求 you.com streamingSearch 接口
+type YouUpstream struct {
+	Label    string
+	Endpoint string                       // 为空时使用 youEndpoint
+	Params   func(ChatRequest) url.Values // 查询参数构造函数
+	Header   http.Header
+	Client   *http.Client
+	Lenient  bool // 宽松解析：接受非 SSE 行，[DONE] 不结束读取
+	Preview  bool // 将响应体的前 200 字节写入日志
+}
+
+func (u *YouUpstream) Name() string {
+	if u.Label != "" {
+		return u.Label
+	}
+	return "you.com"
+}
+
+func (u *YouUpstream) Stream(ctx context.Context, req ChatRequest) (<-chan Event, error) {
+	return u.open(ctx, u.targetURL(req))
+}
+
+// targetURL 构造 you.com 请求地址
+func (u *YouUpstream) targetURL(req ChatRequest) string {
+	endpoint := u.Endpoint
+	if endpoint == "" {
+		endpoint = youEndpoint
+	}
+	params := u.Params
+	if params == nil {
+		params = minimalParams
+	}
+	return endpoint + "?" + params(req).Encode()
+}
+
+func (u *YouUpstream) open(ctx context.Context, target string) (<-chan Event, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = u.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s response status: %d", u.Name(), resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if u.Preview {
+			bodyPreview, _ := io.ReadAll(io.LimitReader(resp.Body, previewSize))
+			log.Printf("Response body preview (first %d bytes): %s", len(bodyPreview), string(bodyPreview))
+		}
+		return nil, &UpstreamError{Upstream: u.Name(), StatusCode: resp.StatusCode}
+	}
+
+	if u.Preview {
+		resp.Body = newPreviewReader(resp.Body, previewSize)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		readYouStream(resp.Body, u.Lenient, func(ev Event) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return events, nil
+}
+
+// previewSize 是调试日志中响应体预览的字节数
+const previewSize = 200
+
+// previewReader 在正常读取的同时记录前 limit 个字节，
+// 读满、读到结尾或关闭时写入一次日志，不会阻塞流式读取，也不需要重新请求
+type previewReader struct {
+	io.ReadCloser
+	buf    []byte
+	limit  int
+	logged bool
+}
+
+func newPreviewReader(body io.ReadCloser, limit int) *previewReader {
+	return &previewReader{ReadCloser: body, buf: make([]byte, 0, limit), limit: limit}
+}
+
+func (p *previewReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	if !p.logged {
+		p.buf = append(p.buf, b[:min(n, p.limit-len(p.buf))]...)
+		if len(p.buf) >= p.limit || err != nil {
+			p.flush()
+		}
+	}
+	return n, err
+}
+
+func (p *previewReader) Close() error {
+	p.flush()
+	return p.ReadCloser.Close()
+}
+
+func (p *previewReader) flush() {
+	if p.logged {
+		return
+	}
+	p.logged = true
+	log.Printf("Response body preview (first %d bytes): %s", len(p.buf), string(p.buf))
+}
+
+// CORSProxyUpstream 通过 CORS 代理访问 you.com，用于绕过 Cloudflare
+type CORSProxyUpstream struct {
+	YouUpstream
+	Proxy  string // 代理前缀，目标地址直接拼接在其后
+	Escape bool   // 拼接前对目标地址做 QueryEscape
+}
+
+func (u *CORSProxyUpstream) Name() string {
+	if u.Label != "" {
+		return u.Label
+	}
+	return u.Proxy
+}
+
+func (u *CORSProxyUpstream) Stream(ctx context.Context, req ChatRequest) (<-chan Event, error) {
+	target := u.targetURL(req)
+	if u.Escape {
+		target = url.QueryEscape(target)
+	}
+	// 让日志中显示代理名称
+	inner := u.YouUpstream
+	inner.Label = u.Name()
+	return inner.open(ctx, u.Proxy+target)
+}
+
+// MockUpstream 返回预设内容，不访问网络，用于本地开发和测试
+type MockUpstream struct {
+	Label  string
+	Tokens []string
+	Reply  func(ChatRequest) []string // 非空时优先于 Tokens
+	Err    error                      // 非空时 Stream 直接返回该错误
+	Delay  time.Duration              // 每个 token 之间的间隔
+}
+
+func (m *MockUpstream) Name() string {
+	if m.Label != "" {
+		return m.Label
+	}
+	return "mock"
+}
+
+func (m *MockUpstream) Stream(ctx context.Context, req ChatRequest) (<-chan Event, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	tokens := m.Tokens
+	if m.Reply != nil {
+		tokens = m.Reply(req)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for _, token := range tokens {
+			if m.Delay > 0 {
+				time.Sleep(m.Delay)
+			}
+			select {
+			case events <- Event{Text: token}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// collectText 读取整个事件流并拼接文本
+func collectText(events <-chan Event) (string, error) {
+	var content strings.Builder
+	var err error
+	for ev := range events {
+		if ev.Err != nil {
+			err = ev.Err
+			continue
+		}
+		content.WriteString(ev.Text)
+	}
+	return content.String(), err
+}
+
+// sseMaxSize 是上游单个 SSE 事件的大小上限，由 SSE_MAX_SIZE 配置（字节），
+// 搜索结果事件可能远大于 bufio.Scanner 默认的 64KB
+var sseMaxSize = positiveIntFromEnv("SSE_MAX_SIZE", sse.DefaultMaxSize)
+
+// readYouStream 逐个解析 you.com 的 SSE 事件，按事件类型转换为 Event，emit 返回 false 时停止读取。
+// 宽松模式下还接受代理返回的非 SSE 行（如纯 JSON），按顶层字段识别事件类型
+func readYouStream(body io.Reader, lenient bool, emit func(Event) bool) {
+	reader := sse.NewReader(body, sseMaxSize)
+	reader.Lenient = lenient
+	isDebug := os.Getenv("DEBUG") == "true"
+
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, sse.ErrTooLarge) {
+			log.Printf("WARN [you]: skipping SSE event larger than %d bytes", sseMaxSize)
+			continue
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			emit(Event{Err: err})
+			return
+		}
+		if isDebug {
+			log.Printf("SSE event %q: %s", record.Event, record.Data)
+		}
+
+		data := record.Data
+		if data == "[DONE]" {
+			if lenient {
+				continue
+			}
+			return
+		}
+		if data == "" || data == "{}" {
+			continue
+		}
+
+		decoded, err := decodeYouEvent(record.Event, data)
+		if err != nil {
+			if isDebug {
+				log.Printf("Failed to decode %s event: %v, data: %s", record.Event, err, data)
+			}
+			continue
+		}
+
+		var ev Event
+		switch e := decoded.(type) {
+		case *youTokenEvent:
+			ev = Event{Text: e.YouChatToken, Reasoning: e.reasoning()}
+		case *youSearchEvent:
+			ev = Event{Citations: e.citations()}
+		case *youClarificationEvent:
+			ev = Event{Text: e.text()}
+		case *youUpdateEvent:
+			if isDebug {
+				log.Printf("you.com update: %s", e.YouChatUpdate.Msg)
+			}
+		case *youDoneEvent:
+			return
+		case *youErrorEvent:
+			emit(Event{Err: fmt.Errorf("you.com error event: %s", e.message())})
+			return
+		default:
+			logUnknownYouEvent(record.Event, data)
+		}
+		if ev.Text == "" && ev.Reasoning == "" && len(ev.Citations) == 0 {
+			continue
+		}
+		if !emit(ev) {
+			return
+		}
+	}
+}
+
+// fullParams 是主请求使用的完整参数，包含历史对话
+func fullParams(req ChatRequest) url.Values {
+	chatHistoryJSON, _ := json.Marshal(req.History)
+	settings := req.settings()
+
+	q := url.Values{}
+	q.Add("q", req.prompt())
+	q.Add("page", "1")
+	q.Add("count", settings.searchCount())
+	q.Add("safeSearch", settings.SafeSearch)
+	q.Add("mkt", settings.Market)
+	q.Add("enable_worklow_generation_ux", strconv.FormatBool(settings.Workflows))
+	q.Add("domain", "youchat")
+	q.Add("use_personalization_extraction", "true")
+	q.Add("pastChatLength", strconv.Itoa(len(req.History)))
+	q.Add("selectedChatMode", settings.ChatMode)
+	q.Add("selectedAiModel", req.Model)
+	q.Add("enable_agent_clarification_questions", strconv.FormatBool(settings.Clarifications))
+	q.Add("use_nested_youchat_updates", "true")
+	q.Add("chat", string(chatHistoryJSON))
+	return q
+}
+
+// basicParams 不带历史对话的常用参数
+func basicParams(req ChatRequest) url.Values {
+	settings := req.settings()
+
+	params := url.Values{}
+	params.Add("q", req.prompt())
+	params.Add("page", "1")
+	params.Add("count", settings.searchCount())
+	params.Add("safeSearch", settings.SafeSearch)
+	params.Add("mkt", settings.Market)
+	params.Add("domain", "youchat")
+	params.Add("selectedAiModel", req.Model)
+	params.Add("selectedChatMode", settings.ChatMode)
+	settings.addChangedParams(params)
+	return params
+}
+
+// minimalParams 只包含问题、模型和修改过的设置
+func minimalParams(req ChatRequest) url.Values {
+	params := url.Values{}
+	params.Add("q", req.prompt())
+	params.Add("domain", "youchat")
+	params.Add("selectedAiModel", req.Model)
+	req.settings().addChangedParams(params)
+	return params
+}
+
+var browserHeader = http.Header{
+	"User-Agent":         {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+	"Accept":             {"text/event-stream"},
+	"Accept-Language":    {"en-US,en;q=0.9"},
+	"Accept-Encoding":    {"gzip, deflate, br"},
+	"Referer":            {"https://you.com/"},
+	"Origin":             {"https://you.com"},
+	"DNT":                {"1"},
+	"Connection":         {"keep-alive"},
+	"Sec-Fetch-Dest":     {"empty"},
+	"Sec-Fetch-Mode":     {"cors"},
+	"Sec-Fetch-Site":     {"same-origin"},
+	"sec-ch-ua":          {"\"Not_A Brand\";v=\"8\", \"Chromium\";v=\"120\", \"Google Chrome\";v=\"120\""},
+	"sec-ch-ua-mobile":   {"?0"},
+	"sec-ch-ua-platform": {"\"Windows\""},
+}
+
+// newUpstreams 根据 UPSTREAM 环境变量创建主上游和备用上游链
+//
+//	UPSTREAM=proxy（默认）  通过 proxy.cors.sh 访问 you.com
+//	UPSTREAM=direct        直接访问 you.com
+//	UPSTREAM=mock          不访问网络，回显问题
+func newUpstreams() (Upstream, []Upstream) {
+	fallbackClient := &http.Client{Timeout: 30 * time.Second}
+	fallbacks := []Upstream{
+		// 原始方法
+		&CORSProxyUpstream{
+			YouUpstream: YouUpstream{
+				Label:   "cors.sh",
+				Params:  basicParams,
+				Client:  fallbackClient,
+				Lenient: true,
+				Header: http.Header{
+					"x-cors-api-key": {corsShKey},
+					"User-Agent":     {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"},
+				},
+			},
+			Proxy: corsShProxy,
+		},
+		// 简化方法
+		&CORSProxyUpstream{
+			YouUpstream: YouUpstream{
+				Label:   "cors.sh-simplified",
+				Params:  minimalParams,
+				Client:  fallbackClient,
+				Lenient: true,
+				Header:  http.Header{"x-cors-api-key": {corsShKey}},
+			},
+			Proxy: corsShProxy,
+		},
+		// 替代代理
+		&CORSProxyUpstream{
+			YouUpstream: YouUpstream{Params: minimalParams, Client: fallbackClient, Lenient: true},
+			Proxy:       "https://cors-anywhere.herokuapp.com/",
+			Escape:      true,
+		},
+		&CORSProxyUpstream{
+			YouUpstream: YouUpstream{Params: minimalParams, Client: fallbackClient, Lenient: true},
+			Proxy:       "https://api.allorigins.win/raw?url=",
+			Escape:      true,
+		},
+		&CORSProxyUpstream{
+			YouUpstream: YouUpstream{Params: minimalParams, Client: fallbackClient, Lenient: true},
+			Proxy:       "https://thingproxy.freeboard.io/fetch/",
+			Escape:      true,
+		},
+		// 直接调用（可能被CORS阻止）
+		&YouUpstream{
+			Label:   "you.com-direct",
+			Params:  minimalParams,
+			Client:  fallbackClient,
+			Lenient: true,
+			Header: http.Header{
+				"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"},
+				"Referer":    {"https://you.com/"},
+				"Origin":     {"https://you.com"},
+			},
+		},
+	}
+
+	primaryClient := &http.Client{Timeout: 300 * time.Second}
+	switch os.Getenv("UPSTREAM") {
+	case "mock":
+		return &MockUpstream{Reply: func(req ChatRequest) []string {
+			return []string{"Mock reply from " + req.Model + ": ", req.Query}
+		}}, nil
+	case "direct":
+		return &YouUpstream{
+			Label:   "you.com",
+			Params:  fullParams,
+			Header:  browserHeader,
+			Client:  primaryClient,
+			Preview: true,
+		}, fallbacks
+	default:
+		header := browserHeader.Clone()
+		header.Set("x-cors-api-key", corsShKey)
+		return &CORSProxyUpstream{
+			YouUpstream: YouUpstream{
+				Label:   "cors.sh",
+				Params:  fullParams,
+				Header:  header,
+				Client:  primaryClient,
+				Preview: true,
+			},
+			Proxy: corsShProxy,
+		}, fallbacks
+	}
+}
+
+// 主上游和备用上游链，测试中可以替换
+var primaryUpstream, fallbackUpstreams = newUpstreams()
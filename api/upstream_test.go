@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withUpstreams 在测试期间替换主上游和备用上游链
+func withUpstreams(t *testing.T, primary Upstream, fallbacks ...Upstream) {
+	t.Helper()
+	oldPrimary, oldFallbacks := primaryUpstream, fallbackUpstreams
+	primaryUpstream, fallbackUpstreams = primary, fallbacks
+	t.Cleanup(func() {
+		primaryUpstream, fallbackUpstreams = oldPrimary, oldFallbacks
+	})
+}
+
+// newCountingServer 返回一个模拟 you.com 的 SSE 服务，并统计请求次数
+func newCountingServer(t *testing.T, tokens ...string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "event: youChatToken\ndata: {\"youChatToken\":%q}\n\n", token)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestHandlerSingleUpstreamRequest(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			srv, hits := newCountingServer(t, "Hello", ", world")
+			withUpstreams(t, &YouUpstream{
+				Endpoint: srv.URL,
+				Params:   fullParams,
+				Client:   srv.Client(),
+				Preview:  true,
+			})
+
+			body := fmt.Sprintf(`{"model":"gpt-4o","stream":%v,"messages":[{"role":"user","content":"hi"}]}`, stream)
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+
+			Handler(rec, req)
+
+			if got := hits.Load(); got != 1 {
+				t.Fatalf("upstream hits = %d, want 1", got)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "Hello") {
+				t.Fatalf("response missing upstream content: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPreviewReaderPassesThroughBody(t *testing.T) {
+	payload := strings.Repeat("data: {\"youChatToken\":\"x\"}\n\n", 50)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, payload)
+	}))
+	defer srv.Close()
+
+	u := &YouUpstream{Endpoint: srv.URL, Client: srv.Client(), Preview: true}
+	events, err := u.Stream(context.Background(), ChatRequest{Model: "gpt_4o", Query: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := collectText(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != strings.Repeat("x", 50) {
+		t.Fatalf("content = %q, want 50 x", content)
+	}
+}
+
+func TestYouStreamLargeAndMultiLineEvents(t *testing.T) {
+	var hits strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&hits, `{"url":"https://example.com/%d","name":"Result %d","snippet":"%s"},`, i, i, strings.Repeat("s", 40))
+	}
+	search := `{"search":{"third_party_search_results":[` + strings.TrimSuffix(hits.String(), ",") + `]}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "event: thirdPartySearchResults\r\ndata: %s\r\n\r\n", search)
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "event: youChatToken\ndata: {\"youChatToken\":\ndata: \"Hello\"}\n\n")
+		fmt.Fprint(w, "event: youChatToken\ndata: {\"youChatToken\":\" world\"}")
+	}))
+	defer srv.Close()
+
+	u := &YouUpstream{Endpoint: srv.URL, Client: srv.Client()}
+	events, err := u.Stream(context.Background(), ChatRequest{Model: "gpt_4o", Query: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	citations := 0
+	for ev := range events {
+		if ev.Err != nil {
+			t.Fatalf("stream error: %v", ev.Err)
+		}
+		text.WriteString(ev.Text)
+		citations += len(ev.Citations)
+	}
+	if len(search) <= 64<<10 {
+		t.Fatalf("search payload is only %d bytes", len(search))
+	}
+	if text.String() != "Hello world" || citations != 2000 {
+		t.Errorf("text = %q, citations = %d", text.String(), citations)
+	}
+}
+
+func TestYouUpstreamRequest(t *testing.T) {
+	var query url.Values
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, header = r.URL.Query(), r.Header
+		fmt.Fprint(w, "event: youChatToken\ndata: {\"youChatToken\":\"ok\"}\n\n")
+	}))
+	defer srv.Close()
+
+	u := &YouUpstream{Endpoint: srv.URL, Params: basicParams, Header: http.Header{"X-Test": {"1"}}, Client: srv.Client()}
+	if u.Name() != "you.com" {
+		t.Errorf("Name() = %q", u.Name())
+	}
+	events, err := u.Stream(context.Background(), ChatRequest{Model: "gpt_4o", Query: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := collectText(events); err != nil || content != "ok" {
+		t.Fatalf("content = %q, %v", content, err)
+	}
+	if query.Get("q") != "hi" || query.Get("selectedAiModel") != "gpt_4o" || query.Get("domain") != "youchat" {
+		t.Errorf("query = %v", query)
+	}
+	if header.Get("X-Test") != "1" {
+		t.Errorf("configured header was not sent: %v", header)
+	}
+	if u.Header.Get("X-Test") != "1" || len(u.Header) != 1 {
+		t.Errorf("request mutated the configured header: %v", u.Header)
+	}
+}
+
+func TestYouUpstreamStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	u := &YouUpstream{Label: "test", Endpoint: srv.URL, Client: srv.Client(), Preview: true}
+	_, err := u.Stream(context.Background(), ChatRequest{Model: "gpt_4o", Query: "hi"})
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusTooManyRequests || upstreamErr.Upstream != "test" {
+		t.Errorf("err = %v, want UpstreamError 429 from test", err)
+	}
+}
+
+func TestCORSProxyUpstream(t *testing.T) {
+	var gotPath, gotURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotURL = r.URL.Path, r.URL.Query().Get("url")
+		fmt.Fprint(w, "data: {\"youChatToken\":\"proxied\"}\n\n")
+	}))
+	defer srv.Close()
+
+	req := ChatRequest{Model: "gpt_4o", Query: "hi"}
+	target := youEndpoint + "?" + minimalParams(req).Encode()
+	tests := []struct {
+		name     string
+		upstream *CORSProxyUpstream
+		check    func() bool
+	}{
+		{
+			"prefix",
+			&CORSProxyUpstream{YouUpstream: YouUpstream{Client: srv.Client()}, Proxy: srv.URL + "/fetch/"},
+			func() bool { return gotPath == "/fetch/"+youEndpoint },
+		},
+		{
+			"escaped",
+			&CORSProxyUpstream{YouUpstream: YouUpstream{Client: srv.Client()}, Proxy: srv.URL + "/raw?url=", Escape: true},
+			func() bool { return gotPath == "/raw" && gotURL == target },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.upstream.Name() != tt.upstream.Proxy {
+				t.Errorf("Name() = %q, want the proxy", tt.upstream.Name())
+			}
+			events, err := tt.upstream.Stream(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content, err := collectText(events); err != nil || content != "proxied" {
+				t.Errorf("content = %q, %v", content, err)
+			}
+			if !tt.check() {
+				t.Errorf("proxy received path %q, url %q; target %q", gotPath, gotURL, target)
+			}
+		})
+	}
+}
+
+func TestMockUpstream(t *testing.T) {
+	failure := errors.New("offline")
+	if _, err := (&MockUpstream{Err: failure}).Stream(context.Background(), ChatRequest{}); err != failure {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+
+	m := &MockUpstream{Tokens: []string{"ignored"}, Reply: func(req ChatRequest) []string {
+		return []string{"echo: ", req.Query}
+	}}
+	events, _ := m.Stream(context.Background(), ChatRequest{Query: "hi"})
+	if content, _ := collectText(events); content != "echo: hi" || m.Name() != "mock" {
+		t.Errorf("content = %q, name = %q", content, m.Name())
+	}
+
+	// 取消后通道关闭，不再发送剩余的 token
+	ctx, cancel := context.WithCancel(context.Background())
+	events, _ = (&MockUpstream{Tokens: []string{"a", "b", "c"}, Delay: time.Millisecond}).Stream(ctx, ChatRequest{})
+	<-events
+	cancel()
+	for range events {
+	}
+}
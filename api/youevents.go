@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// you.com streamingSearch 的 SSE 事件名。JSON 负载的顶层字段通常与事件名相同
+const (
+	youEventToken         = "youChatToken"
+	youEventSearchResults = "thirdPartySearchResults"
+	youEventSerpResults   = "youChatSerpResults"
+	youEventUpdate        = "youChatUpdate"
+	youEventClarification = "youChatClarificationQuestion"
+	youEventDone          = "done"
+	youEventError         = "error"
+)
+
+// youTokenEvent 是回答的一段增量。推理模型的思考过程放在 reasoning_content（部分模型为 thinking 或 reasoning）中
+type youTokenEvent struct {
+	YouChatToken     string `json:"youChatToken"`
+	ReasoningContent string `json:"reasoning_content"`
+	Thinking         string `json:"thinking"`
+	Reasoning        string `json:"reasoning"`
+}
+
+// reasoning 返回第一个非空的思考过程字段
+func (e *youTokenEvent) reasoning() string {
+	for _, r := range []string{e.ReasoningContent, e.Thinking, e.Reasoning} {
+		if r != "" {
+			return r
+		}
+	}
+	return ""
+}
+
+// youSearchResult 是一条搜索结果，不同事件使用 url/link、name/title 和 snippet/description 两套字段名
+type youSearchResult struct {
+	URL         string `json:"url"`
+	Link        string `json:"link"`
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Snippet     string `json:"snippet"`
+	Description string `json:"description"`
+}
+
+// youSearchEvent 是搜索结果事件。thirdPartySearchResults 使用 search.third_party_search_results 或 search.hits，
+// youChatSerpResults 使用同名数组，较旧的响应直接使用 hits
+type youSearchEvent struct {
+	Search struct {
+		ThirdPartySearchResults []youSearchResult `json:"third_party_search_results"`
+		Hits                    []youSearchResult `json:"hits"`
+	} `json:"search"`
+	YouChatSerpResults []youSearchResult `json:"youChatSerpResults"`
+	Hits               []youSearchResult `json:"hits"`
+}
+
+// citations 将搜索结果转换为来源，跳过没有 URL 的结果
+func (e *youSearchEvent) citations() []Citation {
+	var citations []Citation
+	for _, list := range [][]youSearchResult{e.Search.ThirdPartySearchResults, e.Search.Hits, e.YouChatSerpResults, e.Hits} {
+		for _, result := range list {
+			c := Citation{
+				URL:     firstNonEmpty(result.URL, result.Link),
+				Title:   firstNonEmpty(result.Name, result.Title),
+				Snippet: firstNonEmpty(result.Snippet, result.Description),
+			}
+			if c.URL != "" {
+				citations = append(citations, c)
+			}
+		}
+	}
+	return citations
+}
+
+// youUpdateEvent 是界面上显示的进度提示（如“正在搜索”），不属于回答
+type youUpdateEvent struct {
+	YouChatUpdate struct {
+		Msg  string `json:"msg"`
+		Done bool   `json:"done"`
+	} `json:"youChatUpdate"`
+}
+
+// youClarificationEvent 是开启 enable_agent_clarification_questions 时模型向用户提出的澄清问题
+type youClarificationEvent struct {
+	YouChatClarificationQuestion struct {
+		Question string   `json:"question"`
+		Options  []string `json:"options"`
+	} `json:"youChatClarificationQuestion"`
+}
+
+// text 将澄清问题渲染为回答文本，API 调用方无法交互，只能在下一条消息中回答
+func (e *youClarificationEvent) text() string {
+	q := e.YouChatClarificationQuestion
+	if q.Question == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(q.Question)
+	for _, option := range q.Options {
+		b.WriteString("\n- " + option)
+	}
+	return b.String()
+}
+
+// youDoneEvent 表示回答结束，负载是纯文本 "I'm done"
+type youDoneEvent struct{}
+
+// youErrorEvent 是上游在流中报告的错误，负载可能是 JSON 也可能是纯文本
+type youErrorEvent struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *youErrorEvent) message() string {
+	if msg := firstNonEmpty(e.Message, e.Error); msg != "" {
+		return msg
+	}
+	return "unknown error"
+}
+
+// youEventDecoders 按事件名返回对应的空事件，JSON 负载解码到其中
+var youEventDecoders = map[string]func() interface{}{
+	youEventToken:         func() interface{} { return &youTokenEvent{} },
+	youEventSearchResults: func() interface{} { return &youSearchEvent{} },
+	youEventSerpResults:   func() interface{} { return &youSearchEvent{} },
+	youEventUpdate:        func() interface{} { return &youUpdateEvent{} },
+	youEventClarification: func() interface{} { return &youClarificationEvent{} },
+}
+
+// youEventKeys 将 JSON 负载的顶层字段对应到事件名，用于没有 event: 字段的事件（名为 message），
+// 例如经过代理的流或只转发 data: 行的实现
+var youEventKeys = []struct{ key, event string }{
+	{"youChatToken", youEventToken},
+	{"reasoning_content", youEventToken},
+	{"thinking", youEventToken},
+	{"reasoning", youEventToken},
+	{"search", youEventSearchResults},
+	{"youChatSerpResults", youEventSerpResults},
+	{"hits", youEventSerpResults},
+	{"youChatUpdate", youEventUpdate},
+	{"youChatClarificationQuestion", youEventClarification},
+	{"error", youEventError},
+}
+
+// decodeYouEvent 按 SSE 事件名解码 you.com 事件，返回 *youTokenEvent、*youSearchEvent、*youUpdateEvent、
+// *youClarificationEvent、*youDoneEvent 或 *youErrorEvent。未知事件返回 nil，由调用方忽略
+func decodeYouEvent(name, data string) (interface{}, error) {
+	switch name {
+	case youEventDone:
+		return &youDoneEvent{}, nil
+	case youEventError:
+		ev := &youErrorEvent{}
+		if json.Unmarshal([]byte(data), ev) != nil {
+			ev.Message = data
+		}
+		return ev, nil
+	case "message":
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(data), &fields); err != nil {
+			return nil, err
+		}
+		name = ""
+		for _, k := range youEventKeys {
+			if _, ok := fields[k.key]; ok {
+				name = k.event
+				break
+			}
+		}
+		if name == youEventError {
+			return decodeYouEvent(name, data)
+		}
+	}
+
+	newEvent, ok := youEventDecoders[name]
+	if !ok {
+		return nil, nil
+	}
+	ev := newEvent()
+	if err := json.Unmarshal([]byte(data), ev); err != nil {
+		return nil, fmt.Errorf("decode %s event: %w", name, err)
+	}
+	return ev, nil
+}
+
+// maxLoggedYouEvents 是记录日志的未知事件种类上限
+const maxLoggedYouEvents = 64
+
+// unknownEventLog 记录已经记录过日志的未知事件种类，每种只记录一次。
+// 种类由上游决定，最多保留 limit 种，之后的新种类不再记录，异常的上游不能让它无限增长
+type unknownEventLog struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	limit int
+	full  bool
+}
+
+// first 报告 key 是否第一次出现且仍在上限之内；刚达到上限时记录一条提示
+func (l *unknownEventLog) first(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[key] || l.full {
+		return false
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	if len(l.seen) >= l.limit {
+		l.full = true
+		log.Printf("WARN [you]: more than %d kinds of unknown events, no longer logging new ones", l.limit)
+		return false
+	}
+	l.seen[key] = true
+	return true
+}
+
+// loggedYouEvents 记录 you.com 未知事件的种类
+var loggedYouEvents = &unknownEventLog{limit: maxLoggedYouEvents}
+
+// logUnknownYouEvent 记录未知的 you.com 事件，方便发现上游新增的事件类型。
+// 没有事件名的 message 事件按 JSON 顶层字段区分，否则只有第一种未知结构会被记录
+func logUnknownYouEvent(name, data string) {
+	key := name
+	if name == "message" {
+		var fields map[string]json.RawMessage
+		json.Unmarshal([]byte(data), &fields)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		key = "message{" + strings.Join(keys, ",") + "}"
+	}
+	key = truncateString(key, 200)
+	if loggedYouEvents.first(key) {
+		log.Printf("WARN [you]: ignoring unknown event %s: %s", key, truncateString(data, 200))
+	}
+}
+
+// firstNonEmpty 返回第一个非空字符串
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
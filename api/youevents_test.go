@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+// readYouEvents 解析一段 you.com 事件流并返回全部事件
+func readYouEvents(stream string, lenient bool) []Event {
+	var events []Event
+	readYouStream(strings.NewReader(stream), lenient, func(ev Event) bool {
+		events = append(events, ev)
+		return true
+	})
+	return events
+}
+
+func TestReadYouStreamTypedEvents(t *testing.T) {
+	stream := "event: youChatUpdate\ndata: {\"youChatUpdate\":{\"msg\":\"Searching the web\",\"done\":false}}\n\n" +
+		"event: youChatSerpResults\ndata: {\"youChatSerpResults\":[{\"link\":\"https://a.example\",\"title\":\"A\",\"description\":\"about a\"},{\"title\":\"no url\"}]}\n\n" +
+		"event: youChatToken\ndata: {\"reasoning_content\":\"thinking\"}\n\n" +
+		"event: youChatToken\ndata: {\"youChatToken\":\"Hello\"}\n\n" +
+		"event: youChatIntent\ndata: {\"data\":\"leak\",\"result\":\"leak\"}\n\n" +
+		"data: {\"text\":\"leak\",\"answer\":\"leak\"}\n\n" +
+		"event: youChatClarificationQuestion\ndata: {\"youChatClarificationQuestion\":{\"question\":\" Which one?\",\"options\":[\"a\",\"b\"]}}\n\n" +
+		"event: done\ndata: I'm done\n\n" +
+		"event: youChatToken\ndata: {\"youChatToken\":\"after done\"}\n\n"
+
+	var text, reasoning strings.Builder
+	var citations []Citation
+	for _, ev := range readYouEvents(stream, false) {
+		if ev.Err != nil {
+			t.Fatalf("unexpected error: %v", ev.Err)
+		}
+		text.WriteString(ev.Text)
+		reasoning.WriteString(ev.Reasoning)
+		citations = append(citations, ev.Citations...)
+	}
+	if got, want := text.String(), "Hello Which one?\n- a\n- b"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if reasoning.String() != "thinking" {
+		t.Errorf("reasoning = %q", reasoning.String())
+	}
+	if len(citations) != 1 || citations[0] != (Citation{URL: "https://a.example", Title: "A", Snippet: "about a"}) {
+		t.Errorf("citations = %+v", citations)
+	}
+}
+
+func TestReadYouStreamLenientJSON(t *testing.T) {
+	stream := "{\"youChatToken\":\"Hi\"}\n" +
+		"{\"result\":\"leak\",\"data\":\"leak\",\"content\":\"leak\"}\n" +
+		"data: [DONE]\n\n" +
+		"{\"search\":{\"hits\":[{\"url\":\"https://b.example\",\"name\":\"B\"}]}}\n"
+
+	events := readYouEvents(stream, true)
+	if len(events) != 2 || events[0].Text != "Hi" || len(events[1].Citations) != 1 {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestReadYouStreamErrorEvent(t *testing.T) {
+	for _, data := range []string{`{"error":"rate limited"}`, "rate limited"} {
+		events := readYouEvents("event: youChatToken\ndata: {\"youChatToken\":\"partial\"}\n\nevent: error\ndata: "+data+"\n\n", false)
+		if len(events) != 2 || events[1].Err == nil || !strings.Contains(events[1].Err.Error(), "rate limited") {
+			t.Errorf("%s: events = %+v", data, events)
+		}
+	}
+}
+
+func TestUnknownYouEventLoggedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	old := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(old)
+
+	readYouEvents(strings.Repeat("event: youChatTestOnly\ndata: {\"x\":1}\n\n", 3), false)
+	if n := strings.Count(buf.String(), "youChatTestOnly"); n != 1 {
+		t.Errorf("unknown event logged %d times, want 1:\n%s", n, buf.String())
+	}
+
+	// 没有事件名的事件按顶层字段区分
+	readYouEvents("data: {\"testOnlyA\":1}\n\ndata: {\"testOnlyB\":1,\"z\":2}\n\ndata: {\"testOnlyA\":2}\n\n", false)
+	for _, key := range []string{"message{testOnlyA}", "message{testOnlyB,z}"} {
+		if n := strings.Count(buf.String(), key); n != 1 {
+			t.Errorf("%s logged %d times, want 1:\n%s", key, n, buf.String())
+		}
+	}
+}
+
+func TestUnknownEventLogLimit(t *testing.T) {
+	var buf bytes.Buffer
+	old := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(old)
+
+	l := &unknownEventLog{limit: 2}
+	for i, want := range []bool{true, true, false, false} {
+		if got := l.first(fmt.Sprintf("kind%d", i)); got != want {
+			t.Errorf("first(kind%d) = %v, want %v", i, got, want)
+		}
+	}
+	if l.first("kind0") || len(l.seen) != 2 {
+		t.Errorf("seen = %v, want the first 2 kinds only", l.seen)
+	}
+	if n := strings.Count(buf.String(), "no longer logging"); n != 1 {
+		t.Errorf("limit warning logged %d times, want 1", n)
+	}
+}
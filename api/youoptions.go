@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// YouOptions 是扩展参数 you_options，覆盖本次请求发往 you.com 的搜索和模式设置，未设置的字段使用部署默认值
+type YouOptions struct {
+	WebSearch      *bool  `json:"web_search,omitempty"`     // false 时不进行网页搜索，只使用模型本身
+	SafeSearch     string `json:"safe_search,omitempty"`    // off、moderate 或 strict
+	Market         string `json:"market,omitempty"`         // 搜索市场，如 en-US、zh-HK
+	ChatMode       string `json:"chat_mode,omitempty"`      // custom、default、research 或 agent
+	Count          *int   `json:"count,omitempty"`          // 搜索结果数
+	Workflows      *bool  `json:"workflows,omitempty"`      // enable_worklow_generation_ux
+	Clarifications *bool  `json:"clarifications,omitempty"` // enable_agent_clarification_questions
+}
+
+// YouSettings 是合并部署默认值、请求头和 you_options 之后的最终设置
+type YouSettings struct {
+	WebSearch      bool
+	SafeSearch     string // Off、Moderate 或 Strict，与 you.com 参数一致
+	Market         string
+	ChatMode       string
+	Count          int
+	Workflows      bool
+	Clarifications bool
+}
+
+// maxSearchCount 是 count 的上限
+const maxSearchCount = 50
+
+var (
+	safeSearchValues = map[string]string{"off": "Off", "moderate": "Moderate", "strict": "Strict"}
+	chatModes        = []string{"custom", "default", "research", "agent"}
+	marketPattern    = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{2,4})?$`)
+)
+
+// builtinYouSettings 是内置缺省值，与早期版本的硬编码参数一致
+var builtinYouSettings = YouSettings{
+	WebSearch:      true,
+	SafeSearch:     "Moderate",
+	Market:         "zh-HK",
+	ChatMode:       "custom",
+	Count:          10,
+	Workflows:      true,
+	Clarifications: true,
+}
+
+// newYouSettingsFromEnv 读取部署默认值，缺省时与早期版本的硬编码参数一致
+//
+//	YOU_WEB_SEARCH      true
+//	YOU_SAFE_SEARCH     Moderate
+//	YOU_MARKET          zh-HK
+//	YOU_CHAT_MODE       custom
+//	YOU_SEARCH_COUNT    10
+//	YOU_WORKFLOWS       true
+//	YOU_CLARIFICATIONS  true
+//
+// 取值不合法时记录错误并使用缺省值。
+func newYouSettingsFromEnv() YouSettings {
+	defaults := builtinYouSettings
+
+	var opts YouOptions
+	for env, target := range map[string]**bool{
+		"YOU_WEB_SEARCH":     &opts.WebSearch,
+		"YOU_WORKFLOWS":      &opts.Workflows,
+		"YOU_CLARIFICATIONS": &opts.Clarifications,
+	} {
+		if v := os.Getenv(env); v != "" {
+			if b, err := strconv.ParseBool(v); err == nil {
+				*target = &b
+			} else {
+				log.Printf("ERROR [you]: invalid %s %q, using default", env, v)
+			}
+		}
+	}
+	if v := os.Getenv("YOU_SEARCH_COUNT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			opts.Count = &n
+		} else {
+			log.Printf("ERROR [you]: invalid YOU_SEARCH_COUNT %q, using default", v)
+		}
+	}
+	opts.SafeSearch = os.Getenv("YOU_SAFE_SEARCH")
+	opts.Market = os.Getenv("YOU_MARKET")
+	opts.ChatMode = os.Getenv("YOU_CHAT_MODE")
+
+	settings, apiErr := defaults.apply(&opts)
+	if apiErr != nil {
+		log.Printf("ERROR [you]: invalid deployment default: %s, using built-in defaults", apiErr.Message)
+		return defaults
+	}
+	return settings
+}
+
+// Resolve 依次应用请求头（X-You-Web-Search、X-You-Safe-Search、X-You-Market、X-You-Chat-Mode、X-You-Search-Count）
+// 和请求体中的 you_options，返回本次请求的设置
+func (s YouSettings) Resolve(header http.Header, body *YouOptions) (YouSettings, *APIError) {
+	opts, apiErr := youOptionsFromHeader(header)
+	if apiErr != nil {
+		return s, apiErr
+	}
+	settings, apiErr := s.apply(opts)
+	if apiErr != nil {
+		return s, apiErr
+	}
+	if body == nil {
+		return settings, nil
+	}
+	return settings.apply(body)
+}
+
+// youOptionsFromHeader 读取 X-You-* 请求头
+func youOptionsFromHeader(header http.Header) (*YouOptions, *APIError) {
+	opts := &YouOptions{
+		SafeSearch: header.Get("X-You-Safe-Search"),
+		Market:     header.Get("X-You-Market"),
+		ChatMode:   header.Get("X-You-Chat-Mode"),
+	}
+	if v := header.Get("X-You-Web-Search"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errInvalidRequest("X-You-Web-Search must be true or false")
+		}
+		opts.WebSearch = &b
+	}
+	if v := header.Get("X-You-Search-Count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errInvalidRequest("X-You-Search-Count must be an integer")
+		}
+		opts.Count = &n
+	}
+	return opts, nil
+}
+
+// apply 用 opts 中设置了的字段覆盖 s，并校验取值
+func (s YouSettings) apply(opts *YouOptions) (YouSettings, *APIError) {
+	if opts.WebSearch != nil {
+		s.WebSearch = *opts.WebSearch
+	}
+	if opts.SafeSearch != "" {
+		value, ok := safeSearchValues[strings.ToLower(opts.SafeSearch)]
+		if !ok {
+			return s, errInvalidRequest("you_options.safe_search must be one of off, moderate or strict").withParam("you_options.safe_search")
+		}
+		s.SafeSearch = value
+	}
+	if opts.Market != "" {
+		if !marketPattern.MatchString(opts.Market) {
+			return s, errInvalidRequest(fmt.Sprintf("you_options.market %q is not a valid market code such as en-US", opts.Market)).withParam("you_options.market")
+		}
+		s.Market = opts.Market
+	}
+	if opts.ChatMode != "" {
+		mode := strings.ToLower(opts.ChatMode)
+		valid := false
+		for _, m := range chatModes {
+			valid = valid || m == mode
+		}
+		if !valid {
+			return s, errInvalidRequest("you_options.chat_mode must be one of " + strings.Join(chatModes, ", ")).withParam("you_options.chat_mode")
+		}
+		s.ChatMode = mode
+	}
+	if opts.Count != nil {
+		if *opts.Count < 1 || *opts.Count > maxSearchCount {
+			return s, errInvalidRequest(fmt.Sprintf("you_options.count must be between 1 and %d", maxSearchCount)).withParam("you_options.count")
+		}
+		s.Count = *opts.Count
+	}
+	if opts.Workflows != nil {
+		s.Workflows = *opts.Workflows
+	}
+	if opts.Clarifications != nil {
+		s.Clarifications = *opts.Clarifications
+	}
+	return s, nil
+}
+
+// searchCount 返回实际发送的 count，关闭网页搜索时为 0
+func (s YouSettings) searchCount() string {
+	if !s.WebSearch {
+		return "0"
+	}
+	return strconv.Itoa(s.Count)
+}
+
+// addChangedParams 将与内置缺省值不同的设置写入 params。备用上游只发送精简的参数，
+// 不带上这些设置时，部署默认值、请求头和 you_options 中的修改（如关闭网页搜索）会被丢弃
+func (s YouSettings) addChangedParams(params url.Values) {
+	b := builtinYouSettings
+	if s.WebSearch != b.WebSearch || s.Count != b.Count {
+		params.Set("count", s.searchCount())
+	}
+	if s.SafeSearch != b.SafeSearch {
+		params.Set("safeSearch", s.SafeSearch)
+	}
+	if s.Market != b.Market {
+		params.Set("mkt", s.Market)
+	}
+	if s.ChatMode != b.ChatMode {
+		params.Set("selectedChatMode", s.ChatMode)
+	}
+	if s.Workflows != b.Workflows {
+		params.Set("enable_worklow_generation_ux", strconv.FormatBool(s.Workflows))
+	}
+	if s.Clarifications != b.Clarifications {
+		params.Set("enable_agent_clarification_questions", strconv.FormatBool(s.Clarifications))
+	}
+}
+
+// defaultYouSettings 是部署默认设置，测试中可以替换
+var defaultYouSettings = newYouSettingsFromEnv()
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestYouOptionsOverrideParams(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, "data: {\"youChatToken\":\"ok\"}\n\n")
+	}))
+	defer srv.Close()
+	withUpstreams(t, &YouUpstream{Endpoint: srv.URL, Params: fullParams, Client: srv.Client()})
+
+	body := `{"model":"gpt-4o","you_options":{"web_search":false,"chat_mode":"Research","clarifications":false},"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-You-Market", "en-US")
+	req.Header.Set("X-You-Safe-Search", "strict")
+	req.Header.Set("X-You-Chat-Mode", "agent")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	want := map[string]string{
+		"mkt":                                  "en-US",
+		"safeSearch":                           "Strict",
+		"selectedChatMode":                     "research", // you_options 优先于请求头
+		"count":                                "0",
+		"enable_agent_clarification_questions": "false",
+		"enable_worklow_generation_ux":         "true",
+	}
+	for param, value := range want {
+		if got := query.Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
+
+func TestYouOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   *YouOptions
+		param  string
+	}{
+		{"bad safe search", nil, &YouOptions{SafeSearch: "extreme"}, "you_options.safe_search"},
+		{"bad market", nil, &YouOptions{Market: "english"}, "you_options.market"},
+		{"bad mode header", http.Header{"X-You-Chat-Mode": {"turbo"}}, nil, "you_options.chat_mode"},
+		{"count out of range", nil, &YouOptions{Count: new(int)}, "you_options.count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			_, apiErr := defaultYouSettings.Resolve(header, tt.body)
+			if apiErr == nil || apiErr.Param == nil || *apiErr.Param != tt.param {
+				t.Errorf("got %v, want error on %s", apiErr, tt.param)
+			}
+		})
+	}
+}
+
+func TestYouOptionsCarriedToFallback(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, "data: {\"youChatToken\":\"ok\"}\n\n")
+	}))
+	defer srv.Close()
+	withUpstreams(t, &MockUpstream{Err: errors.New("primary down")},
+		&YouUpstream{Endpoint: srv.URL, Params: minimalParams, Client: srv.Client()})
+
+	body := `{"model":"gpt-4o","you_options":{"web_search":false,"clarifications":false},"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-You-Market", "en-US")
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	want := map[string]string{
+		"count":                                "0",
+		"mkt":                                  "en-US",
+		"enable_agent_clarification_questions": "false",
+		"safeSearch":                           "", // 未修改的设置不发送
+	}
+	for param, value := range want {
+		if got := query.Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Command you2api 以独立 HTTP 服务的方式运行 you2api，挂载与 Vercel 相同的 handler.Handler。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	handler "you2api/api"
+)
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "监听地址，默认取 LISTEN_ADDR 或 :$PORT")
+	pidFile := flag.String("pidfile", os.Getenv("PID_FILE"), "启动后写入 PID 的文件，退出时删除")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second, "读取整个请求的超时")
+	// 上游客户端超时为 300 秒，写超时需要比它长，否则长时间的 SSE 流会被截断
+	writeTimeout := flag.Duration("write-timeout", 310*time.Second, "写响应的超时")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "keep-alive 连接的空闲超时")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 60*time.Second, "收到 SIGTERM 后等待进行中请求完成的最长时间")
+	hashKey := flag.String("hash-key", "", "打印给定 API 密钥的哈希后退出，用于填写 API_KEYS / API_KEYS_FILE")
+	flag.Parse()
+
+	if *hashKey != "" {
+		fmt.Println(handler.HashKey(*hashKey))
+		return
+	}
+
+	var draining atomic.Bool
+	srv := &http.Server{
+		Handler:           newMux(&draining, http.HandlerFunc(handler.Handler)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+
+	// 先完成监听再写 PID 文件，这样 start.sh 看到 PID 时端口一定已经可用
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			log.Fatalf("Failed to write pid file: %v", err)
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// 再次收到信号时直接退出，不再等待
+	context.AfterFunc(ctx, stop)
+
+	log.Printf("You2Api listening on %s (pid %d)", ln.Addr(), os.Getpid())
+	if err := serve(ctx, srv, ln, &draining, *shutdownTimeout); err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	log.Printf("Server stopped")
+}
+
+// newMux 挂载 /healthz 和 app。draining 为 true 时 /healthz 返回 503，让负载均衡停止分配新请求
+func newMux(draining *atomic.Bool, app http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		status := "ok"
+		if draining.Load() {
+			status = "draining"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"pid":    os.Getpid(),
+		})
+	})
+	mux.Handle("/", app)
+	return mux
+}
+
+// serve 在 ln 上运行 srv，直到出错或 ctx 取消。取消后标记 draining，
+// 等待进行中的请求完成，超过 shutdownTimeout 时强制关闭并返回错误
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, draining *atomic.Bool, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	}
+
+	draining.Store(true)
+	log.Printf("Shutdown signal received, draining in-flight requests (timeout %s)...", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("graceful shutdown incomplete: %w", err)
+	}
+	return nil
+}
+
+// defaultAddr 根据环境变量确定默认监听地址
+func defaultAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// writePIDFile 写入当前进程 PID，若文件中记录的进程仍存活则拒绝覆盖
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && processAlive(pid) {
+			return fmt.Errorf("process %d from %s is still running", pid, path)
+		}
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
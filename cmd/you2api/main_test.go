@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthzDraining(t *testing.T) {
+	var draining atomic.Bool
+	mux := newMux(&draining, http.NotFoundHandler())
+
+	check := func(wantStatus int, want string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		var body struct {
+			Status string `json:"status"`
+			PID    int    `json:"pid"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != wantStatus || body.Status != want || body.PID != os.Getpid() {
+			t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body, wantStatus, want)
+		}
+	}
+	check(http.StatusOK, "ok")
+	draining.Store(true)
+	check(http.StatusServiceUnavailable, "draining")
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var draining atomic.Bool
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		if !draining.Load() {
+			t.Error("request finished before draining started")
+		}
+		io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newMux(&draining, app)}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, &draining, 5*time.Second) }()
+
+	reply := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			reply <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		reply <- string(body)
+	}()
+
+	<-started
+	cancel()
+	for !draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v before the in-flight request finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-reply; got != "done" {
+		t.Errorf("in-flight request got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-block
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var draining atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, &http.Server{Handler: app}, ln, &draining, 20*time.Millisecond) }()
+
+	go http.Get("http://" + ln.Addr().String() + "/stuck")
+	<-started
+	cancel()
+	if err := <-served; err == nil || !strings.Contains(err.Error(), "graceful shutdown incomplete") {
+		t.Errorf("serve = %v, want shutdown timeout error", err)
+	}
+}
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "you2api.pid")
+	if err := writePIDFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("pid file = %q", data)
+	}
+
+	// 文件中的进程（当前进程）仍存活，拒绝覆盖
+	if err := writePIDFile(path); err == nil {
+		t.Error("overwrote the pid file of a running process")
+	}
+
+	// 残留的 PID 文件可以覆盖
+	os.WriteFile(path, []byte("2147483646\n"), 0o644)
+	if err := writePIDFile(path); err != nil {
+		t.Errorf("stale pid file: %v", err)
+	}
+}
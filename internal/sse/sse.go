@@ -0,0 +1,216 @@
+// Package sse 按 WHATWG HTML 标准中的 Server-Sent Events 解析规则读取事件流。
+//
+// 与逐行匹配 "data: " 前缀的做法相比，它支持 event:/id: 字段、注释行、多行 data:、
+// CRLF/LF/CR 三种行尾和开头的 BOM，并限制单个事件的大小，而不是受 bufio.Scanner 64KB 的限制。
+// 与标准不同的是，流在没有结尾空行时结束，最后一个事件仍然会被返回，因为部分代理会去掉它；
+// retry: 字段被忽略。
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultMaxSize 是单行和单个事件 data 的默认大小上限
+const DefaultMaxSize = 4 << 20
+
+// ErrTooLarge 表示某个事件超过了大小上限。该事件被丢弃，Reader 仍然可以继续读取下一个事件
+var ErrTooLarge = errors.New("sse: event exceeds maximum size")
+
+// Event 是一个已分发的事件
+type Event struct {
+	Event string // 事件名，没有 event: 字段时为 message
+	Data  string // 多个 data: 字段以换行连接
+	ID    string // 最近一次 id: 字段的值，跨事件保留
+}
+
+// Reader 从流中逐个读取事件
+type Reader struct {
+	// Lenient 为 true 时，字段名不是 event、data、id、retry 的行（例如代理直接返回的 JSON 行）
+	// 作为只有 Data 的事件立即返回，而不是按标准忽略
+	Lenient bool
+
+	r       *bufio.Reader
+	maxSize int
+	line    []byte
+	started bool // 已经读过第一行，用于去掉 BOM
+	skipLF  bool // 上一行以 CR 结尾，紧接着的 LF 属于同一个行尾
+
+	event     string
+	data      []byte
+	hasData   bool
+	lastID    string
+	oversized bool
+}
+
+// NewReader 返回一个读取 r 的 Reader，maxSize 小于等于 0 时使用 DefaultMaxSize
+func NewReader(r io.Reader, maxSize int) *Reader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Reader{r: bufio.NewReader(r), maxSize: maxSize}
+}
+
+// Next 返回下一个事件。流结束时返回 io.EOF；事件超过大小上限时返回 ErrTooLarge，之后可以继续调用
+func (r *Reader) Next() (Event, error) {
+	for {
+		line, tooLong, err := r.readLine()
+		if err == io.EOF {
+			if r.oversized {
+				r.reset()
+				return Event{}, ErrTooLarge
+			}
+			if r.hasData {
+				return r.dispatch(), nil
+			}
+			return Event{}, io.EOF
+		}
+		if err != nil {
+			return Event{}, err
+		}
+
+		if len(line) == 0 && !tooLong {
+			if r.oversized {
+				r.reset()
+				return Event{}, ErrTooLarge
+			}
+			if r.hasData {
+				return r.dispatch(), nil
+			}
+			r.reset()
+			continue
+		}
+		if tooLong && !r.oversized && r.Lenient && !isField(line) {
+			// 宽松模式下非 SSE 行本身就是一个事件，超长时只丢弃这一行，不影响后续的行
+			return Event{}, ErrTooLarge
+		}
+		if tooLong {
+			r.oversized = true
+		}
+		if r.oversized {
+			continue
+		}
+		if ev, ok := r.field(line); ok {
+			return ev, nil
+		}
+	}
+}
+
+// field 处理一行字段，Lenient 模式下遇到非 SSE 行时返回该行
+func (r *Reader) field(line []byte) (Event, bool) {
+	if line[0] == ':' {
+		return Event{}, false
+	}
+	name, value := line, []byte(nil)
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		name, value = line[:i], line[i+1:]
+		value = bytes.TrimPrefix(value, []byte(" "))
+	}
+
+	switch string(name) {
+	case "event":
+		r.event = string(value)
+	case "data":
+		if len(r.data)+len(value)+1 > r.maxSize {
+			r.oversized = true
+			return Event{}, false
+		}
+		r.data = append(r.data, value...)
+		r.data = append(r.data, '\n')
+		r.hasData = true
+	case "id":
+		if bytes.IndexByte(value, 0) < 0 {
+			r.lastID = string(value)
+		}
+	case "retry":
+	default:
+		if r.Lenient {
+			return Event{Event: "message", Data: string(line), ID: r.lastID}, true
+		}
+	}
+	return Event{}, false
+}
+
+// isField 判断一行是否是 SSE 字段或注释，超长的行只需要看开头。
+// 开头只有 BOM 的超长行去掉 BOM 后为空，无法判断，按普通行处理
+func isField(line []byte) bool {
+	if len(line) == 0 {
+		return false
+	}
+	if line[0] == ':' {
+		return true
+	}
+	name := line
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		name = line[:i]
+	}
+	switch string(name) {
+	case "event", "data", "id", "retry":
+		return true
+	}
+	return false
+}
+
+// dispatch 返回当前累积的事件并清空缓冲
+func (r *Reader) dispatch() Event {
+	ev := Event{
+		Event: r.event,
+		Data:  string(r.data[:len(r.data)-1]),
+		ID:    r.lastID,
+	}
+	if ev.Event == "" {
+		ev.Event = "message"
+	}
+	r.reset()
+	return ev
+}
+
+func (r *Reader) reset() {
+	r.event = ""
+	r.data = r.data[:0]
+	r.hasData = false
+	r.oversized = false
+}
+
+// readLine 读取一行，不含行尾。超过 maxSize 的部分被丢弃，tooLong 为 true。
+// 最后一行没有行尾时照常返回，之后返回 io.EOF
+func (r *Reader) readLine() (line []byte, tooLong bool, err error) {
+	r.line = r.line[:0]
+	read := false
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && read {
+				break
+			}
+			return nil, false, err
+		}
+		if r.skipLF {
+			r.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		if b == '\n' {
+			break
+		}
+		if b == '\r' {
+			r.skipLF = true
+			break
+		}
+		read = true
+		if len(r.line) >= r.maxSize {
+			tooLong = true
+			continue
+		}
+		r.line = append(r.line, b)
+	}
+
+	if !r.started {
+		r.started = true
+		r.line = bytes.TrimPrefix(r.line, []byte("\xEF\xBB\xBF"))
+	}
+	return r.line, tooLong, nil
+}
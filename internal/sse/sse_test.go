@@ -0,0 +1,170 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll 读取全部事件，超过上限的事件记为 Event{Event: "<too large>"}
+func readAll(t testing.TB, r io.Reader, maxSize int, lenient bool) []Event {
+	t.Helper()
+	reader := NewReader(r, maxSize)
+	reader.Lenient = lenient
+	var events []Event
+	for {
+		ev, err := reader.Next()
+		switch {
+		case err == io.EOF:
+			return events
+		case errors.Is(err, ErrTooLarge):
+			events = append(events, Event{Event: "<too large>"})
+		case err != nil:
+			t.Fatalf("unexpected error: %v", err)
+		default:
+			events = append(events, ev)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{
+			"named events and ids",
+			"event: youChatToken\nid: 1\ndata: {\"a\":1}\n\nevent: done\ndata: I'm done\n\n",
+			[]Event{{"youChatToken", `{"a":1}`, "1"}, {"done", "I'm done", "1"}},
+		},
+		{
+			"multi-line data and comments",
+			": keep-alive\ndata: first\ndata:second\ndata\n\n",
+			[]Event{{"message", "first\nsecond\n", ""}},
+		},
+		{
+			"CRLF, CR and BOM",
+			"\xEF\xBB\xBFdata: a\r\n\r\ndata: b\r\rdata: c\n\n",
+			[]Event{{"message", "a", ""}, {"message", "b", ""}, {"message", "c", ""}},
+		},
+		{
+			"no data is not dispatched",
+			"event: ping\n\nid: 7\n\ndata: x\n\n",
+			[]Event{{"message", "x", "7"}},
+		},
+		{
+			"last event without blank line",
+			"data: tail",
+			[]Event{{"message", "tail", ""}},
+		},
+		{
+			"value keeps all but one leading space",
+			"data:  two\n\n",
+			[]Event{{"message", " two", ""}},
+		},
+		{
+			"id with NUL is ignored",
+			"id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			[]Event{{"message", "a", "1"}, {"message", "b", "1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, iotest.OneByteReader(strings.NewReader(tt.input)), 0, false)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderMaxSize(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	input := "data: " + big + "\n\ndata: small\n\ndata: " + big[:40] + "\ndata: " + big[:40] + "\n\ndata: ok\n\n"
+	got := readAll(t, strings.NewReader(input), 64, false)
+	want := []Event{{Event: "<too large>"}, {"message", "small", ""}, {Event: "<too large>"}, {"message", "ok", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// 超过 bufio.Scanner 默认 64KB 限制的事件
+	large := strings.Repeat("y", 200<<10)
+	got = readAll(t, strings.NewReader("data: "+large+"\n\n"), 0, false)
+	if len(got) != 1 || got[0].Data != large {
+		t.Errorf("large event was not read intact")
+	}
+}
+
+func TestReaderLenient(t *testing.T) {
+	input := "{\"text\":\"hi\"}\ndata: x\n\n"
+	if got := readAll(t, strings.NewReader(input), 0, false); !reflect.DeepEqual(got, []Event{{"message", "x", ""}}) {
+		t.Errorf("strict: got %q", got)
+	}
+	want := []Event{{"message", `{"text":"hi"}`, ""}, {"message", "x", ""}}
+	if got := readAll(t, strings.NewReader(input), 0, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("lenient: got %q, want %q", got, want)
+	}
+
+	// 没有空行分隔的 JSON 行中有一行超长时，只丢弃这一行
+	input = "{\"text\":\"" + strings.Repeat("x", 100) + "\"}\n{\"a\":1}\n{\"b\":2}\n"
+	want = []Event{{Event: "<too large>"}, {"message", `{"a":1}`, ""}, {"message", `{"b":2}`, ""}}
+	if got := readAll(t, strings.NewReader(input), 32, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("lenient oversized line: got %q, want %q", got, want)
+	}
+	// 超长的 data: 行仍然丢弃整个事件
+	input = "data: " + strings.Repeat("x", 100) + "\n{\"a\":1}\n\ndata: ok\n\n"
+	want = []Event{{Event: "<too large>"}, {"message", "ok", ""}}
+	if got := readAll(t, strings.NewReader(input), 32, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("lenient oversized field: got %q, want %q", got, want)
+	}
+}
+
+// encode 按标准格式写出事件，用于往返测试
+func encode(events []Event) string {
+	var b strings.Builder
+	for _, ev := range events {
+		if ev.Event != "message" {
+			b.WriteString("event: " + ev.Event + "\n")
+		}
+		b.WriteString("id: " + ev.ID + "\n")
+		for _, line := range strings.Split(ev.Data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add("event: a\ndata: b\n\n", 0)
+	f.Add("data: x\r\ndata: y\r\r: c\nid: 1\n\ndata", 8)
+	f.Add("\xEF\xBB\xBFdata:\n\n{\"json\":true}\n", 3)
+	f.Fuzz(func(t *testing.T, input string, maxSize int) {
+		maxSize = maxSize%256 + 1
+		if maxSize < 1 {
+			maxSize += 256
+		}
+		for _, lenient := range []bool{false, true} {
+			whole := readAll(t, strings.NewReader(input), maxSize, lenient)
+			bytewise := readAll(t, iotest.OneByteReader(strings.NewReader(input)), maxSize, lenient)
+			if !reflect.DeepEqual(whole, bytewise) {
+				t.Fatalf("result depends on read boundaries: %q vs %q", whole, bytewise)
+			}
+			for _, ev := range whole {
+				if len(ev.Data) > maxSize {
+					t.Fatalf("event data %d bytes exceeds max %d", len(ev.Data), maxSize)
+				}
+			}
+		}
+
+		// 解析结果重新编码后应当得到相同的事件
+		events := readAll(t, strings.NewReader(input), 0, false)
+		if again := readAll(t, strings.NewReader(encode(events)), 0, false); !reflect.DeepEqual(again, events) {
+			t.Fatalf("round trip: got %q, want %q", again, events)
+		}
+	})
+}
@@ -1,18 +1,43 @@
 #!/bin/bash
 
+PID_FILE=.pid
+LOG_FILE=server.log
+BIN=bin/you2api
+PORT=${PORT:-8080}
+ADDR=${LISTEN_ADDR:-:$PORT}
+HEALTH_URL=${HEALTH_URL:-http://127.0.0.1:${ADDR##*:}/healthz}
+
 # 检查服务是否已在运行
-if [ -f .pid ]; then
-  echo "服务已在运行中，PID: $(cat .pid)。如果需要重启，请先运行 stop.sh。"
+if [ -f "$PID_FILE" ]; then
+  PID=$(cat "$PID_FILE")
+  if kill -0 "$PID" 2>/dev/null; then
+    echo "服务已在运行中，PID: $PID。如果需要重启，请先运行 stop.sh。"
+    exit 1
+  fi
+  echo "发现过期的 PID 文件 (PID: $PID)，已删除。"
+  rm -f "$PID_FILE"
+fi
+
+echo "正在编译服务..."
+if ! go build -o "$BIN" ./cmd/you2api; then
+  echo "编译失败。"
   exit 1
 fi
 
 echo "正在启动服务..."
-# 在后台运行 Go 程序，并将输出重定向到日志文件
-nohup go run . > server.log 2>&1 &
+# 服务进程自己写入 PID 文件，并在退出时删除
+nohup "$BIN" -addr "$ADDR" -pidfile "$PID_FILE" > "$LOG_FILE" 2>&1 &
 
-# 获取后台进程的 PID 并保存
-PID=$!
-echo $PID > .pid
+# 等待健康检查通过
+for _ in $(seq 1 20); do
+  if curl -fs "$HEALTH_URL" > /dev/null 2>&1; then
+    echo "服务已成功启动，PID: $(cat "$PID_FILE")，监听: $ADDR"
+    echo "日志文件位于: $LOG_FILE"
+    exit 0
+  fi
+  sleep 0.5
+done
 
-echo "服务已成功启动，PID: $PID"
-echo "日志文件位于: server.log"
+echo "服务启动失败，日志末尾:"
+tail -n 20 "$LOG_FILE"
+exit 1
@@ -1,23 +1,36 @@
 #!/bin/bash
 
+PID_FILE=.pid
+# 等待进行中的请求（包括 SSE 流）结束的最长秒数，应不小于服务的 -shutdown-timeout
+WAIT_SECONDS=${WAIT_SECONDS:-65}
+
 # 检查 .pid 文件是否存在
-if [ ! -f .pid ]; then
-  echo "服务未在运行 (找不到 .pid 文件)。"
+if [ ! -f "$PID_FILE" ]; then
+  echo "服务未在运行 (找不到 $PID_FILE 文件)。"
   exit 1
 fi
 
 # 从文件中读取 PID
-PID=$(cat .pid)
-
-echo "正在停止服务，PID: $PID"
-# 杀死进程
-kill $PID
+PID=$(cat "$PID_FILE")
 
-# 检查进程是否已成功停止
-if [ $? -eq 0 ]; then
-  echo "服务已成功停止。"
-  # 删除 .pid 文件
-  rm .pid
-else
-  echo "停止服务失败。请手动检查进程: ps aux | grep 'go run'"
+if ! kill -0 "$PID" 2>/dev/null; then
+  echo "进程 $PID 已不存在，清理 PID 文件。"
+  rm -f "$PID_FILE"
+  exit 0
 fi
+
+echo "正在停止服务，PID: $PID (等待进行中的请求完成...)"
+# 发送 SIGTERM，服务会停止接收新请求并等待现有请求完成
+kill -TERM "$PID"
+
+for _ in $(seq 1 "$WAIT_SECONDS"); do
+  if ! kill -0 "$PID" 2>/dev/null; then
+    rm -f "$PID_FILE"
+    echo "服务已成功停止。"
+    exit 0
+  fi
+  sleep 1
+done
+
+echo "服务在 ${WAIT_SECONDS} 秒内未退出。可使用 kill -9 $PID 强制停止。"
+exit 1